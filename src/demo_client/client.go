@@ -10,6 +10,7 @@ import "net/http"
 import _ "net/http/pprof"
 
 type TestClient struct {
+	rudp.RudpInterBase
 }
 
 func (t *TestClient) OnSessionCreate(sessionId int64, code int) {
@@ -46,8 +47,7 @@ func main() {
 	clientPort := iniObj.ReadInt("CLIENT", "port", -1)
 	statAddr := iniObj.ReadString("STAT", "addr", "error")
 
-	var obj = rudp.GetReliableUdp()
-	obj.Init()
+	var obj = rudp.NewReliableUdp()
 
 	if serverIp != "error" && serverPort != -1 {
 		err := obj.Listen("0.0.0.0", serverPort)
@@ -80,7 +80,10 @@ func main() {
 		if sid != 0 {
 			buff := fmt.Sprintf("index=%d", index)
 			index += 1
-			obj.SendData(sid, []byte(buff))
+			err := obj.SendData(sid, []byte(buff))
+			if err != nil {
+				fclog.ERROR("SendData error! err=%s", err.Error())
+			}
 		}
 
 	}
@@ -10,6 +10,7 @@ import "net/http"
 import _ "net/http/pprof"
 
 type TestServer struct {
+	rudp.RudpInterBase
 }
 
 func (t *TestServer) OnSessionCreate(sessionId int64, code int) {
@@ -43,8 +44,7 @@ func main() {
 	serverPort := iniObj.ReadInt("SERVER", "port", -1)
 	statAddr := iniObj.ReadString("STAT", "addr", "error")
 
-	var obj = rudp.GetReliableUdp()
-	obj.Init()
+	var obj = rudp.NewReliableUdp()
 
 	if serverIp != "error" && serverPort != -1 {
 		err := obj.Listen("0.0.0.0", serverPort)
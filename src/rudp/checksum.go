@@ -0,0 +1,41 @@
+package rudp
+
+import "hash/crc32"
+import "encoding/binary"
+
+const (
+	CHECKSUM_NONE   = 0
+	CHECKSUM_CRC32C = 1
+)
+
+const (
+	CHECKSUM_LEN = 4
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func isChecksum(checksum int) bool {
+	return checksum == CHECKSUM_NONE || checksum == CHECKSUM_CRC32C
+}
+
+// appendChecksum returns a copy of the datagram with its checksum trailer,
+// b may be retained for retransmission and is left untouched.
+func appendChecksum(b []byte) []byte {
+	data := make([]byte, len(b)+CHECKSUM_LEN)
+	copy(data, b)
+	binary.BigEndian.PutUint32(data[len(b):], crc32.Checksum(b, crc32cTable))
+	return data
+}
+
+// verifyChecksum returns the datagram without its checksum trailer, false
+// when the trailer is missing or doesn't match.
+func verifyChecksum(b []byte) ([]byte, bool) {
+	if len(b) < CHECKSUM_LEN {
+		return nil, false
+	}
+
+	data := b[:len(b)-CHECKSUM_LEN]
+	sum := binary.BigEndian.Uint32(b[len(b)-CHECKSUM_LEN:])
+
+	return data, crc32.Checksum(data, crc32cTable) == sum
+}
@@ -0,0 +1,161 @@
+package rudp
+
+import "net"
+import "testing"
+import "time"
+
+// corruptProxy forwards datagrams between one client and target, flipping
+// a byte of every nth datagram sent to target.
+func corruptProxy(t *testing.T, target int, every int) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	targetAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: target}
+
+	go func() {
+		var client *net.UDPAddr
+		b := make([]byte, 65536)
+		n := 0
+
+		for {
+			bLen, addr, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+
+			if addr.Port == target {
+				if client != nil {
+					conn.WriteToUDP(b[:bLen], client)
+				}
+				continue
+			}
+
+			client = addr
+			n += 1
+			if n%every == 0 {
+				b[bLen/2] ^= 0xff
+			}
+			conn.WriteToUDP(b[:bLen], targetAddr)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestChecksumDropsCorruptDatagrams(t *testing.T) {
+	srvInter := new(testInter)
+	cliInter := new(testInter)
+
+	srv := NewReliableUdp()
+	srv.SetUdpInterface(srvInter)
+	srv.SetChecksum(CHECKSUM_CRC32C)
+	cli := NewReliableUdp()
+	cli.SetUdpInterface(cliInter)
+	cli.SetChecksum(CHECKSUM_CRC32C)
+	defer cli.Close(CLOSE_POLICY_ABANDON)
+	defer srv.Close(CLOSE_POLICY_ABANDON)
+
+	port := freePort(t)
+	if err := srv.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	sid, err := cli.CreateSession("127.0.0.1", corruptProxy(t, port, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "session create", func() bool {
+		return cliInter.count(func() int { return len(cliInter.created) }) > 0
+	})
+	if cliInter.created[0] != UDP_SESSION_RS_OK {
+		t.Fatalf("session create code=%d", cliInter.created[0])
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := cli.SendData(sid, []byte{byte(i), byte(i), byte(i), byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "data", func() bool {
+		return srvInter.count(func() int { return len(srvInter.recv) }) == 50
+	})
+	for i, b := range srvInter.recv {
+		if len(b) != 4 || b[0] != byte(i) || b[3] != byte(i) {
+			t.Fatalf("packet %d corrupted: %v", i, b)
+		}
+	}
+
+	if srv.GetCorruptCount() == 0 {
+		t.Fatal("corrupt datagrams not counted")
+	}
+}
+
+func TestChecksumMismatchRejected(t *testing.T) {
+	srvInter := new(testInter)
+	cliInter := new(testInter)
+
+	srv := NewReliableUdp()
+	srv.SetUdpInterface(srvInter)
+	srv.SetChecksum(CHECKSUM_CRC32C)
+	cli := NewReliableUdp()
+	cli.SetUdpInterface(cliInter)
+	defer cli.Close(CLOSE_POLICY_ABANDON)
+	defer srv.Close(CLOSE_POLICY_ABANDON)
+
+	port := freePort(t)
+	if err := srv.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cli.CreateSession("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+
+	// Registrations without a trailer are dropped as corrupt, retransmits
+	// included.
+	waitFor(t, "corrupt registrations", func() bool {
+		return srv.GetCorruptCount() >= 2
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	if sessionCount(srv) != 0 {
+		t.Fatal("session registered without a checksum")
+	}
+	cliInter.lock.Lock()
+	defer cliInter.lock.Unlock()
+	for _, code := range cliInter.created {
+		if code == UDP_SESSION_RS_OK {
+			t.Fatal("session created without a checksum")
+		}
+	}
+}
+
+func TestAppendChecksumKeepsBuffer(t *testing.T) {
+	buf := make([]byte, 4, 4+CHECKSUM_LEN)
+	copy(buf, "data")
+	spare := buf[:cap(buf)]
+
+	b := appendChecksum(buf)
+	if data, ok := verifyChecksum(b); !ok || string(data) != "data" {
+		t.Fatalf("checksum not verified data=%q", data)
+	}
+
+	// The spare capacity of the retained datagram isn't written.
+	for i := len(buf); i < len(spare); i++ {
+		if spare[i] != 0 {
+			t.Fatal("checksum written into the original buffer")
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package rudp
+
+// PacketCodec frames every datagram of an endpoint. EncodePacket wraps an
+// encoded message of session sid, IsValidPacket rejects malformed datagrams
+// and GetPacketData returns the message of a valid one, or nil when it can't
+// be decoded. RudpEncrypt is the default codec.
+type PacketCodec interface {
+	EncodePacket(sid int64, b []byte) []byte
+	IsValidPacket(b []byte) bool
+	GetPacketData(b []byte) []byte
+}
+
+// SessionCodec is implemented by codecs that keep per-session state, such as
+// keys installed by the registration handshake.
+type SessionCodec interface {
+	AddSession(sid int64, initiator bool)
+	SetSessionKey(sid int64, key []byte) error
+	RemoveSession(sid int64)
+}
+
+// HandshakeCodec is implemented by codecs that can tell a packet sealed with
+// the key used before the handshake, only registration messages are accepted
+// that way.
+type HandshakeCodec interface {
+	OpenPacket(b []byte) ([]byte, bool)
+}
+
+var _ PacketCodec = (*RudpEncrypt)(nil)
+var _ SessionCodec = (*RudpEncrypt)(nil)
+var _ HandshakeCodec = (*RudpEncrypt)(nil)
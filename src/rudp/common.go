@@ -3,3 +3,61 @@ package rudp
 const (
 	SEQ_MAX_INDEX = 65535
 )
+
+const (
+	ACK_SACK_BITS     = 64
+	ACK_DELAY_DEFAULT = 10 * 1000000
+	ACK_DELAY_MAX     = 500 * 1000000
+	ACK_EVERY_PACKETS = 2
+)
+
+const (
+	FAST_RETRANS_THRESHOLD = 3
+)
+
+// Retransmission timeout bounds, in nanoseconds.
+const (
+	RTO_INIT              = 1000 * 1000000
+	RTO_MIN_DEFAULT       = 200 * 1000000
+	RTO_MAX_DEFAULT       = 60 * 1000 * 1000000
+	RTO_CLOCK_GRANULARITY = 10 * 1000000
+)
+
+const (
+	RECV_WINDOW_DEFAULT  = 1024
+	SEND_WINDOW_MAX      = SEQ_MAX_INDEX / 2
+	SEND_BACKLOG_DEFAULT = 1024
+)
+
+const (
+	WINDOW_POLICY_ERROR = 0
+	WINDOW_POLICY_BLOCK = 1
+	WINDOW_POLICY_QUEUE = 2
+)
+
+const (
+	PACING_RATE_AUTO     = 0
+	PACING_RATE_OFF      = -1
+	PACING_GAIN          = 1.25
+	PACING_BURST_PACKETS = 10
+)
+
+const (
+	MESSAGE_SIZE_MAX_DEFAULT = 256 * 1024
+)
+
+const (
+	DELIVERY_DEFAULT              = -1
+	DELIVERY_RELIABLE_ORDERED     = 0
+	DELIVERY_RELIABLE_UNORDERED   = 1
+	DELIVERY_UNRELIABLE_SEQUENCED = 2
+	DELIVERY_UNRELIABLE           = 3
+)
+
+func isDeliveryMode(mode int) bool {
+	return mode >= DELIVERY_RELIABLE_ORDERED && mode <= DELIVERY_UNRELIABLE
+}
+
+func isWindowPolicy(policy int) bool {
+	return policy >= WINDOW_POLICY_ERROR && policy <= WINDOW_POLICY_QUEUE
+}
@@ -0,0 +1,212 @@
+package rudp
+
+import "math"
+
+const (
+	CONGESTION_NONE    = 0
+	CONGESTION_NEWRENO = 1
+	CONGESTION_CUBIC   = 2
+)
+
+const (
+	CWND_INIT = 10
+	CWND_MIN  = 2
+	CWND_MAX  = SEND_WINDOW_MAX
+
+	CUBIC_C    = 0.4
+	CUBIC_BETA = 0.7
+)
+
+// CongestionController limits the packets a session keeps in flight. The
+// session reports newly acked packets with the RTT sample of the ack (0 when
+// there is none), losses detected by fast retransmit and retransmission
+// timeouts. All calls are made under the ReliableUdp lock.
+type CongestionController interface {
+	OnAck(acked int, rtt int64, curTs int64)
+	OnLoss(curTs int64)
+	OnTimeout(curTs int64)
+	GetWindow() int
+}
+
+func isCongestionAlgo(algo int) bool {
+	return algo >= CONGESTION_NONE && algo <= CONGESTION_CUBIC
+}
+
+func NewCongestionController(algo int) CongestionController {
+	switch algo {
+	case CONGESTION_NEWRENO:
+		c := new(NewReno)
+		c.Init()
+		return c
+	case CONGESTION_CUBIC:
+		c := new(Cubic)
+		c.Init()
+		return c
+	}
+
+	return new(NoCongestion)
+}
+
+// NoCongestion leaves the window to flow control alone.
+type NoCongestion struct {
+}
+
+func (c *NoCongestion) OnAck(acked int, rtt int64, curTs int64) {
+}
+
+func (c *NoCongestion) OnLoss(curTs int64) {
+}
+
+func (c *NoCongestion) OnTimeout(curTs int64) {
+}
+
+func (c *NoCongestion) GetWindow() int {
+	return CWND_MAX
+}
+
+// NewReno is slow start and congestion avoidance as in RFC 5681, halving the
+// window once per round trip on loss.
+type NewReno struct {
+	cwnd     float64
+	ssthresh float64
+	rtt      int64
+	lossTs   int64
+}
+
+func (c *NewReno) Init() {
+	c.cwnd = CWND_INIT
+	c.ssthresh = CWND_MAX
+	c.rtt = 0
+	c.lossTs = 0
+}
+
+func (c *NewReno) OnAck(acked int, rtt int64, curTs int64) {
+	if rtt > 0 {
+		c.rtt = rtt
+	}
+
+	if c.cwnd < c.ssthresh {
+		c.cwnd += float64(acked)
+	} else {
+		c.cwnd += float64(acked) / c.cwnd
+	}
+
+	c.cwnd = math.Min(c.cwnd, CWND_MAX)
+}
+
+func (c *NewReno) OnLoss(curTs int64) {
+	if curTs-c.lossTs < c.rtt {
+		return
+	}
+
+	c.lossTs = curTs
+	c.ssthresh = math.Max(c.cwnd/2, CWND_MIN)
+	c.cwnd = c.ssthresh
+}
+
+func (c *NewReno) OnTimeout(curTs int64) {
+	c.lossTs = curTs
+	c.ssthresh = math.Max(c.cwnd/2, CWND_MIN)
+	c.cwnd = 1
+}
+
+func (c *NewReno) GetWindow() int {
+	return int(c.cwnd)
+}
+
+// Cubic grows the window as a cubic function of the time since the last
+// loss as in RFC 8312, never slower than NewReno would.
+type Cubic struct {
+	cwnd       float64
+	ssthresh   float64
+	wMax       float64
+	wEst       float64
+	k          float64
+	origin     float64
+	epochStart int64
+	rtt        int64
+	lossTs     int64
+}
+
+func (c *Cubic) Init() {
+	c.cwnd = CWND_INIT
+	c.ssthresh = CWND_MAX
+	c.wMax = 0
+	c.wEst = 0
+	c.k = 0
+	c.origin = 0
+	c.epochStart = 0
+	c.rtt = 0
+	c.lossTs = 0
+}
+
+func (c *Cubic) OnAck(acked int, rtt int64, curTs int64) {
+	if rtt > 0 {
+		c.rtt = rtt
+	}
+
+	if c.cwnd < c.ssthresh {
+		c.cwnd = math.Min(c.cwnd+float64(acked), CWND_MAX)
+		return
+	}
+
+	if c.epochStart == 0 {
+		c.epochStart = curTs
+		c.wEst = c.cwnd
+		if c.cwnd < c.wMax {
+			c.k = math.Cbrt(c.wMax * (1 - CUBIC_BETA) / CUBIC_C)
+			c.origin = c.wMax
+		} else {
+			c.k = 0
+			c.origin = c.cwnd
+		}
+	}
+
+	t := float64(curTs-c.epochStart+c.rtt) / 1e9
+	target := c.origin + CUBIC_C*math.Pow(t-c.k, 3)
+
+	if target > c.cwnd {
+		c.cwnd += (target - c.cwnd) / c.cwnd * float64(acked)
+	} else {
+		c.cwnd += 0.01 * float64(acked) / c.cwnd
+	}
+
+	c.wEst += 3 * (1 - CUBIC_BETA) / (1 + CUBIC_BETA) * float64(acked) / c.cwnd
+	if c.wEst > c.cwnd {
+		c.cwnd = c.wEst
+	}
+
+	c.cwnd = math.Min(c.cwnd, CWND_MAX)
+}
+
+func (c *Cubic) OnLoss(curTs int64) {
+	if curTs-c.lossTs < c.rtt {
+		return
+	}
+
+	c.lossTs = curTs
+	c.reduce()
+}
+
+func (c *Cubic) OnTimeout(curTs int64) {
+	c.lossTs = curTs
+	c.reduce()
+	c.cwnd = 1
+}
+
+func (c *Cubic) reduce() {
+	c.epochStart = 0
+
+	if c.cwnd < c.wMax {
+		c.wMax = c.cwnd * (1 + CUBIC_BETA) / 2
+	} else {
+		c.wMax = c.cwnd
+	}
+
+	c.cwnd = math.Max(c.cwnd*CUBIC_BETA, CWND_MIN)
+	c.ssthresh = c.cwnd
+}
+
+func (c *Cubic) GetWindow() int {
+	return int(c.cwnd)
+}
@@ -0,0 +1,134 @@
+package rudp
+
+import "math"
+import "testing"
+
+const (
+	ccAck = iota
+	ccLoss
+	ccTimeout
+)
+
+type ccStep struct {
+	op    int
+	acked int
+	rtt   int64
+	ts    int64
+	count int
+}
+
+func ackStep(acked int, rtt int64, ts int64) ccStep {
+	return ccStep{op: ccAck, acked: acked, rtt: rtt, ts: ts, count: 1}
+}
+
+func lossStep(ts int64) ccStep {
+	return ccStep{op: ccLoss, ts: ts, count: 1}
+}
+
+func timeoutStep(ts int64) ccStep {
+	return ccStep{op: ccTimeout, ts: ts, count: 1}
+}
+
+func repeatStep(step ccStep, count int) ccStep {
+	step.count = count
+	return step
+}
+
+func TestCongestionWindow(t *testing.T) {
+	const ms = int64(1000000)
+	const sec = 1000 * ms
+
+	// Time from a CUBIC reduction at cwnd 20 back to the old maximum.
+	k := int64(math.Cbrt(20*(1-CUBIC_BETA)/CUBIC_C) * 1e9)
+
+	tests := []struct {
+		name  string
+		algo  int
+		steps []ccStep
+		min   int
+		max   int
+	}{
+		{"none", CONGESTION_NONE, []ccStep{lossStep(sec), timeoutStep(sec)}, CWND_MAX, CWND_MAX},
+
+		{"newreno slow start", CONGESTION_NEWRENO, []ccStep{ackStep(10, 0, sec)}, 20, 20},
+		{"newreno avoidance", CONGESTION_NEWRENO, []ccStep{ackStep(10, 0, sec), lossStep(sec), ackStep(10, 0, sec)}, 11, 11},
+		{"newreno loss halves", CONGESTION_NEWRENO, []ccStep{ackStep(10, 100*ms, sec), lossStep(sec)}, 10, 10},
+		{"newreno one loss per rtt", CONGESTION_NEWRENO, []ccStep{ackStep(10, 100*ms, sec), lossStep(sec), lossStep(sec + 50*ms)}, 10, 10},
+		{"newreno loss after rtt", CONGESTION_NEWRENO, []ccStep{ackStep(10, 100*ms, sec), lossStep(sec), lossStep(sec + 200*ms)}, 5, 5},
+		{"newreno timeout", CONGESTION_NEWRENO, []ccStep{ackStep(10, 0, sec), timeoutStep(sec)}, 1, 1},
+		{"newreno slow start after timeout", CONGESTION_NEWRENO, []ccStep{ackStep(10, 0, sec), timeoutStep(sec), ackStep(4, 0, sec)}, 5, 5},
+		{"newreno floor", CONGESTION_NEWRENO, []ccStep{repeatStep(lossStep(sec), 10)}, CWND_MIN, CWND_MIN},
+		{"newreno cap", CONGESTION_NEWRENO, []ccStep{ackStep(CWND_MAX*2, 0, sec)}, CWND_MAX, CWND_MAX},
+
+		{"cubic slow start", CONGESTION_CUBIC, []ccStep{ackStep(10, 0, sec)}, 20, 20},
+		{"cubic loss", CONGESTION_CUBIC, []ccStep{ackStep(10, 0, sec), lossStep(sec)}, 14, 14},
+		{"cubic one loss per rtt", CONGESTION_CUBIC, []ccStep{ackStep(10, 100*ms, sec), lossStep(sec), lossStep(sec + 50*ms)}, 14, 14},
+		// The second loss below the old maximum lowers it further.
+		{"cubic fast convergence", CONGESTION_CUBIC, []ccStep{ackStep(10, 0, sec), lossStep(sec), lossStep(2 * sec)}, 9, 9},
+		{"cubic timeout", CONGESTION_CUBIC, []ccStep{ackStep(10, 0, sec), timeoutStep(sec)}, 1, 1},
+		// Growth slows down approaching the old maximum K after the loss,
+		{"cubic concave", CONGESTION_CUBIC, []ccStep{ackStep(10, 0, sec), lossStep(sec), ackStep(1, 0, sec), repeatStep(ackStep(1, 0, sec+k), 100)}, 19, 19},
+		// and speeds up again beyond it.
+		{"cubic convex", CONGESTION_CUBIC, []ccStep{ackStep(10, 0, sec), lossStep(sec), ackStep(1, 0, sec), repeatStep(ackStep(1, 0, sec+k+3*sec), 100)}, 25, 31},
+		{"cubic cap", CONGESTION_CUBIC, []ccStep{ackStep(CWND_MAX*2, 0, sec)}, CWND_MAX, CWND_MAX},
+	}
+
+	for _, test := range tests {
+		c := NewCongestionController(test.algo)
+		for _, step := range test.steps {
+			for i := 0; i < step.count; i++ {
+				switch step.op {
+				case ccAck:
+					c.OnAck(step.acked, step.rtt, step.ts)
+				case ccLoss:
+					c.OnLoss(step.ts + int64(i)*sec)
+				case ccTimeout:
+					c.OnTimeout(step.ts)
+				}
+			}
+		}
+		if window := c.GetWindow(); window < test.min || window > test.max {
+			t.Errorf("%s: window=%d, want %d..%d", test.name, window, test.min, test.max)
+		}
+	}
+}
+
+func TestCubicEpochReset(t *testing.T) {
+	const sec = int64(1000000000)
+
+	c := new(Cubic)
+	c.Init()
+	c.OnAck(10, 0, sec)
+	c.OnLoss(sec)
+
+	if c.epochStart != 0 || c.wMax != 20 {
+		t.Fatalf("after loss epoch=%d wMax=%f", c.epochStart, c.wMax)
+	}
+
+	c.OnAck(1, 0, 2*sec)
+	if c.epochStart != 2*sec || c.origin != 20 || math.Abs(c.k-math.Cbrt(15)) > 1e-9 {
+		t.Fatalf("epoch=%d origin=%f k=%f", c.epochStart, c.origin, c.k)
+	}
+
+	// Later acks stay in the epoch.
+	c.OnAck(1, 0, 3*sec)
+	if c.epochStart != 2*sec {
+		t.Fatalf("epoch moved to %d", c.epochStart)
+	}
+
+	// Every reduction starts a new one, a timeout too.
+	c.OnTimeout(4 * sec)
+	if c.epochStart != 0 {
+		t.Fatalf("epoch=%d after timeout", c.epochStart)
+	}
+	for c.cwnd < c.ssthresh {
+		c.OnAck(1, 0, 5*sec)
+	}
+	if c.epochStart != 0 {
+		t.Fatal("epoch started in slow start")
+	}
+	c.OnAck(1, 0, 6*sec)
+	if c.epochStart != 6*sec {
+		t.Fatalf("epoch=%d after slow start", c.epochStart)
+	}
+}
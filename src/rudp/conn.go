@@ -0,0 +1,263 @@
+package rudp
+
+import "io"
+import "os"
+import "net"
+import "sync"
+import "time"
+
+const (
+	CONN_DIAL_TIMEOUT     = 5000 * 1000000
+	CONN_READ_BUFFER_SIZE = 256 * 1024
+)
+
+// RudpConn is a session used as a net.Conn. Writes are split into packets
+// and block while the send window is full, reads return the data delivered
+// in order. Read returns io.EOF once the session is gone. Once
+// CONN_READ_BUFFER_SIZE bytes wait to be read, further data stays in the
+// receive buffer of the session, which closes the window of the peer.
+type RudpConn struct {
+	rudp          *ReliableUdp
+	sid           int64
+	remoteAddr    net.UDPAddr
+	lock          sync.Mutex
+	readBuf       []byte
+	readSignal    chan bool
+	createChan    chan int
+	readDeadline  time.Time
+	writeDeadline time.Time
+	eof           bool
+	closed        bool
+	timeout       bool
+}
+
+func newRudpConn(r *ReliableUdp, sid int64, remoteAddr net.UDPAddr) *RudpConn {
+	c := new(RudpConn)
+	c.rudp = r
+	c.sid = sid
+	c.remoteAddr = remoteAddr
+	c.readBuf = make([]byte, 0)
+	c.readSignal = make(chan bool, 1)
+	c.createChan = make(chan int, 1)
+	c.eof = false
+	c.closed = false
+	return c
+}
+
+func (c *RudpConn) GetSid() int64 {
+	return c.sid
+}
+
+func (c *RudpConn) onCreate(code int) {
+	select {
+	case c.createChan <- code:
+	default:
+	}
+}
+
+// onDialTimeout marks a registration that got no answer in time, before the
+// session is dropped.
+func (c *RudpConn) onDialTimeout() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.timeout = true
+}
+
+func (c *RudpConn) isTimeout() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.timeout
+}
+
+func (c *RudpConn) onRecv(b []byte) {
+	c.lock.Lock()
+	if !c.closed {
+		c.readBuf = append(c.readBuf, b...)
+	}
+	c.lock.Unlock()
+
+	c.signal()
+}
+
+func (c *RudpConn) isFull() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.readBuf) >= CONN_READ_BUFFER_SIZE
+}
+
+func (c *RudpConn) onClose() {
+	c.lock.Lock()
+	c.eof = true
+	c.lock.Unlock()
+
+	c.onCreate(UDP_SESSION_RS_ERR)
+	c.signal()
+}
+
+func (c *RudpConn) signal() {
+	select {
+	case c.readSignal <- true:
+	default:
+	}
+}
+
+func (c *RudpConn) Read(b []byte) (int, error) {
+
+	for {
+		c.lock.Lock()
+
+		if c.closed {
+			c.lock.Unlock()
+			c.signal()
+			return 0, net.ErrClosed
+		}
+
+		if len(c.readBuf) > 0 {
+			full := len(c.readBuf) >= CONN_READ_BUFFER_SIZE
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			more := len(c.readBuf) > 0
+			full = full && len(c.readBuf) < CONN_READ_BUFFER_SIZE
+			c.lock.Unlock()
+
+			if more {
+				c.signal()
+			}
+			if full {
+				c.rudp.wakeRead()
+			}
+			return n, nil
+		}
+
+		if c.eof {
+			c.lock.Unlock()
+			c.signal()
+			return 0, io.EOF
+		}
+
+		deadline := c.readDeadline
+		c.lock.Unlock()
+
+		if deadline.IsZero() {
+			<-c.readSignal
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-c.readSignal:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *RudpConn) Write(b []byte) (int, error) {
+
+	n := 0
+
+	for n < len(b) {
+		c.lock.Lock()
+		closed := c.closed
+		deadline := c.writeDeadline
+		c.lock.Unlock()
+
+		if closed {
+			return n, net.ErrClosed
+		}
+
+		// The path MTU may change while writing.
+		chunk, err := c.rudp.GetFragmentSize(c.sid)
+		if err != nil {
+			return n, err
+		}
+
+		end := n + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+
+		err = c.rudp.sendData(c.sid, STREAM_DEFAULT, b[n:end], false, DELIVERY_RELIABLE_ORDERED, true, deadline)
+		if err != nil {
+			return n, err
+		}
+
+		n = end
+	}
+
+	return n, nil
+}
+
+// Close closes the session once the data written has been acknowledged.
+func (c *RudpConn) Close() error {
+	return c.close(CLOSE_POLICY_FLUSH)
+}
+
+func (c *RudpConn) close(policy int) error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	eof := c.eof
+	c.lock.Unlock()
+
+	c.signal()
+	err := c.rudp.CloseSession(c.sid, policy)
+
+	// The peer closing the session first is the normal end of a conn.
+	if eof && (err == ErrUnknownSession || err == ErrSessionClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c *RudpConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP(c.rudp.udpSocket.GetLocalIp()), Port: c.rudp.udpSocket.GetLocalPort()}
+}
+
+func (c *RudpConn) RemoteAddr() net.Addr {
+	c.rudp.lock.Lock()
+	defer c.rudp.lock.Unlock()
+
+	udpSession, exist := c.rudp.sessionMap[c.sid]
+	if exist {
+		c.remoteAddr = udpSession.GetPeerAddr()
+	}
+
+	addr := c.remoteAddr
+	return &addr
+}
+
+func (c *RudpConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *RudpConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline = t
+	c.lock.Unlock()
+
+	c.signal()
+	return nil
+}
+
+func (c *RudpConn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.writeDeadline = t
+	c.lock.Unlock()
+
+	return nil
+}
+
+var _ net.Conn = (*RudpConn)(nil)
@@ -1,14 +1,68 @@
 package rudp
 
 import "bytes"
+import "sync"
+import "crypto/aes"
+import "crypto/cipher"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/binary"
 import "github.com/woodywanghg/gofclog"
 
+const (
+	ENCRYPT_MODE_PLAIN  = 0
+	ENCRYPT_MODE_LEGACY = 1
+	ENCRYPT_MODE_AEAD   = 2
+)
+
+const (
+	AEAD_KEY_MIN_LEN   = 16
+	AEAD_HEADER_LEN    = 16
+	AEAD_TAG_LEN       = 16
+	REPLAY_WINDOW_SIZE = 1024
+)
+
+// rudpCipher is the AEAD state of a session. Until the handshake installs
+// a session key the session uses the initial key of the endpoint, shared by
+// all sessions, packets sealed with it carry random counters. The session id
+// is authenticated in the header, so a packet can't be moved to another
+// session. The session key is
+// fresh for every session, its counters start at 0 and a replay window
+// rejects the counters already received.
+type rudpCipher struct {
+	key         []byte
+	initiator   bool
+	sendAead    cipher.AEAD
+	recvAead    cipher.AEAD
+	initialAead cipher.AEAD
+	sendCounter uint64
+	replay      replayWindow
+}
+
+// replayWindow tracks the last REPLAY_WINDOW_SIZE counters received.
+type replayWindow struct {
+	seen   bool
+	max    uint64
+	bitmap [REPLAY_WINDOW_SIZE / 64]uint64
+}
+
 type RudpEncrypt struct {
-	preKey   []byte
-	endKey   []byte
-	preLen   int
-	endLen   int
-	checkLen int
+	preKey    []byte
+	endKey    []byte
+	preLen    int
+	endLen    int
+	checkLen  int
+	mode      int
+	key       []byte
+	publicKey bool
+	lock      sync.Mutex
+	cipherMap map[int64]*rudpCipher
+
+	// The initial AEADs are built once, packets of unknown sessions don't
+	// cost a key setup.
+	initiatorAead cipher.AEAD
+	responderAead cipher.AEAD
 }
 
 func (r *RudpEncrypt) Init() {
@@ -18,12 +72,138 @@ func (r *RudpEncrypt) Init() {
 	r.preLen = len(r.preKey)
 	r.endLen = len(r.endKey)
 	r.checkLen = r.preLen + r.endLen
+
+	r.mode = ENCRYPT_MODE_LEGACY
+	r.key = make([]byte, 0)
+	r.publicKey = false
+	r.cipherMap = make(map[int64]*rudpCipher, 100)
+	r.initiatorAead = nil
+	r.responderAead = nil
+}
+
+func isEncryptMode(mode int) bool {
+	return mode >= ENCRYPT_MODE_PLAIN && mode <= ENCRYPT_MODE_AEAD
+}
+
+// SetMode selects how packets are encoded. It takes the lock as packets are
+// encoded and decoded concurrently.
+func (r *RudpEncrypt) SetMode(mode int) error {
+	if !isEncryptMode(mode) {
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.mode = mode
+
+	return nil
+}
+
+func (r *RudpEncrypt) GetMode() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.mode
+}
+
+// SetKey sets the endpoint key. The initial key is derived from it, keys
+// installed by SetSessionKey are mixed with it.
+func (r *RudpEncrypt) SetKey(key []byte) error {
+	if len(key) < AEAD_KEY_MIN_LEN {
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.key = append([]byte{}, key...)
+	r.initiatorAead = nil
+	r.responderAead = nil
+	for _, c := range r.cipherMap {
+		c.sendAead = nil
+		c.recvAead = nil
+	}
+
+	return nil
+}
+
+// SetPublicInitialKey lets sessions without an endpoint key start from a
+// fixed public key. Those packets are only integrity
+// protected, the registration handshake must replace the key.
+func (r *RudpEncrypt) SetPublicInitialKey(enable bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.publicKey = enable
+	r.initiatorAead = nil
+	r.responderAead = nil
+}
+
+func (r *RudpEncrypt) AddSession(sid int64, initiator bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cipherMap[sid] = &rudpCipher{initiator: initiator}
+}
+
+// SetSessionKey installs the key agreed by the registration handshake. It is
+// new for every session, so its counters start over. Only the AEAD mode uses
+// session keys, the other modes ignore them.
+func (r *RudpEncrypt) SetSessionKey(sid int64, key []byte) error {
+	if len(key) < AEAD_KEY_MIN_LEN {
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.mode != ENCRYPT_MODE_AEAD {
+		return nil
+	}
+
+	c, exist := r.cipherMap[sid]
+	if !exist {
+		return ErrUnknownSession
+	}
+
+	if c.initialAead == nil && r.buildCipher(sid, c) {
+		c.initialAead = c.recvAead
+	}
+
+	c.key = append([]byte{}, key...)
+	c.sendAead = nil
+	c.recvAead = nil
+	c.sendCounter = 0
+	c.replay = replayWindow{}
+
+	return nil
+}
+
+func (r *RudpEncrypt) RemoveSession(sid int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.cipherMap, sid)
 }
 
 func (r *RudpEncrypt) IsValidPacket(b []byte) bool {
 
+	mode := r.GetMode()
 	packetLen := len(b)
 
+	if mode == ENCRYPT_MODE_PLAIN {
+		return packetLen > 0
+	}
+
+	if mode == ENCRYPT_MODE_AEAD {
+		if packetLen < AEAD_HEADER_LEN+AEAD_TAG_LEN {
+			fclog.ERROR("Invalid packet len")
+			return false
+		}
+		return true
+	}
+
 	if packetLen < r.checkLen {
 		fclog.ERROR("Invalid packet len")
 		return false
@@ -42,7 +222,17 @@ func (r *RudpEncrypt) IsValidPacket(b []byte) bool {
 	return true
 }
 
-func (r *RudpEncrypt) EncodePacket(b []byte) []byte {
+func (r *RudpEncrypt) EncodePacket(sid int64, b []byte) []byte {
+
+	mode := r.GetMode()
+
+	if mode == ENCRYPT_MODE_PLAIN {
+		return b
+	}
+
+	if mode == ENCRYPT_MODE_AEAD {
+		return r.seal(sid, b)
+	}
 
 	encodeData := make([]byte, 0)
 
@@ -53,7 +243,21 @@ func (r *RudpEncrypt) EncodePacket(b []byte) []byte {
 	return encodeData
 }
 
+// GetPacketData returns the payload of a packet accepted by IsValidPacket.
+// In AEAD mode it returns nil when the packet fails authentication.
 func (r *RudpEncrypt) GetPacketData(b []byte) []byte {
+
+	mode := r.GetMode()
+
+	if mode == ENCRYPT_MODE_PLAIN {
+		return b
+	}
+
+	if mode == ENCRYPT_MODE_AEAD {
+		packetData, _ := r.OpenPacket(b)
+		return packetData
+	}
+
 	packetLen := len(b)
 	packetData := b[r.preLen : packetLen-r.endLen]
 
@@ -61,3 +265,238 @@ func (r *RudpEncrypt) GetPacketData(b []byte) []byte {
 
 	return packetData
 }
+
+func (r *RudpEncrypt) seal(sid int64, b []byte) []byte {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var counter uint64
+	var aead cipher.AEAD
+
+	c, exist := r.cipherMap[sid]
+	if exist {
+		if !r.buildCipher(sid, c) {
+			return nil
+		}
+		aead = c.sendAead
+		if len(c.key) > 0 {
+			counter = c.sendCounter
+			c.sendCounter += 1
+		} else {
+			// The initial key is shared by every session.
+			counter = randomCounter()
+		}
+	} else {
+		// Packets for sessions we don't know, such as resets, are sent as the
+		// responder with a random counter that can't collide with a session one.
+		c = &rudpCipher{initiator: false}
+		if !r.buildCipher(sid, c) {
+			return nil
+		}
+		aead = c.sendAead
+		counter = randomCounter()
+	}
+
+	header := make([]byte, AEAD_HEADER_LEN, AEAD_HEADER_LEN+len(b)+AEAD_TAG_LEN)
+	binary.BigEndian.PutUint64(header[0:8], uint64(sid))
+	binary.BigEndian.PutUint64(header[8:16], counter)
+
+	return aead.Seal(header, makeNonce(counter), b, header)
+}
+
+// OpenPacket authenticates and decrypts an AEAD packet. initial is true
+// when the packet was sealed with the key the session used before its
+// handshake installed a session key.
+func (r *RudpEncrypt) OpenPacket(b []byte) (packetData []byte, initial bool) {
+
+	if r.GetMode() != ENCRYPT_MODE_AEAD {
+		return r.GetPacketData(b), false
+	}
+
+	sid := int64(binary.BigEndian.Uint64(b[0:8]))
+	counter := binary.BigEndian.Uint64(b[8:16])
+	header := b[0:AEAD_HEADER_LEN]
+
+	r.lock.Lock()
+	c, exist := r.cipherMap[sid]
+	if !exist {
+		c = &rudpCipher{initiator: false}
+	}
+	if !r.buildCipher(sid, c) {
+		r.lock.Unlock()
+		return nil, false
+	}
+	aead := c.recvAead
+	initialAead := c.initialAead
+	r.lock.Unlock()
+
+	packetData, err := aead.Open(nil, makeNonce(counter), b[AEAD_HEADER_LEN:], header)
+	if err == nil {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		if exist && len(c.key) > 0 && c.recvAead == aead && !c.replay.Check(counter) {
+			fclog.ERROR("Drop replayed packet sid=%d counter=%d", sid, counter)
+			return nil, false
+		}
+
+		return packetData, false
+	}
+
+	if initialAead != nil {
+		packetData, err = initialAead.Open(nil, makeNonce(counter), b[AEAD_HEADER_LEN:], header)
+		if err == nil {
+			return packetData, true
+		}
+	}
+
+	fclog.ERROR("Packet authentication failed sid=%d counter=%d", sid, counter)
+	return nil, false
+}
+
+func (r *RudpEncrypt) buildCipher(sid int64, c *rudpCipher) bool {
+
+	if c.sendAead != nil && c.recvAead != nil {
+		return true
+	}
+
+	var initiatorAead, responderAead cipher.AEAD
+
+	if len(c.key) > 0 {
+		// The endpoint key keeps the session key secret when the handshake
+		// only exchanged nonces.
+		var ok bool
+		initiatorAead, responderAead, ok = newAeadPair(deriveKey(r.key, "rudp session key", c.key))
+		if !ok {
+			return false
+		}
+	} else {
+		if !r.buildInitial(sid) {
+			return false
+		}
+		initiatorAead = r.initiatorAead
+		responderAead = r.responderAead
+	}
+
+	if c.initiator {
+		c.sendAead = initiatorAead
+		c.recvAead = responderAead
+	} else {
+		c.sendAead = responderAead
+		c.recvAead = initiatorAead
+	}
+
+	return true
+}
+
+// buildInitial builds the initial AEADs of the endpoint once, the caller
+// holds the lock.
+func (r *RudpEncrypt) buildInitial(sid int64) bool {
+
+	if r.initiatorAead != nil && r.responderAead != nil {
+		return true
+	}
+
+	if len(r.key) == 0 && !r.publicKey {
+		fclog.ERROR("Encrypt key not set sid=%d", sid)
+		return false
+	}
+
+	initiatorAead, responderAead, ok := newAeadPair(deriveKey(r.key, "rudp initial", nil))
+	if !ok {
+		return false
+	}
+
+	r.initiatorAead = initiatorAead
+	r.responderAead = responderAead
+
+	return true
+}
+
+// newAeadPair derives the AEADs of both directions from key.
+func newAeadPair(key []byte) (cipher.AEAD, cipher.AEAD, bool) {
+
+	initiatorAead, err := newAead(deriveKey(key, "rudp initiator", nil))
+	if err != nil {
+		fclog.ERROR("Create cipher error! err=%s", err.Error())
+		return nil, nil, false
+	}
+
+	responderAead, err := newAead(deriveKey(key, "rudp responder", nil))
+	if err != nil {
+		fclog.ERROR("Create cipher error! err=%s", err.Error())
+		return nil, nil, false
+	}
+
+	return initiatorAead, responderAead, true
+}
+
+func deriveKey(key []byte, label string, context []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	mac.Write(context)
+	return mac.Sum(nil)
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func makeNonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// Check reports whether counter was not received yet and records it.
+// Counters older than the window are rejected.
+func (w *replayWindow) Check(counter uint64) bool {
+
+	if !w.seen || counter > w.max {
+		if !w.seen || counter-w.max >= REPLAY_WINDOW_SIZE {
+			w.bitmap = [REPLAY_WINDOW_SIZE / 64]uint64{}
+		} else {
+			for c := w.max + 1; c < counter; c++ {
+				w.clear(c)
+			}
+		}
+		w.seen = true
+		w.max = counter
+		w.set(counter)
+		return true
+	}
+
+	if w.max-counter >= REPLAY_WINDOW_SIZE || w.has(counter) {
+		return false
+	}
+
+	w.set(counter)
+	return true
+}
+
+func (w *replayWindow) has(counter uint64) bool {
+	i := counter % REPLAY_WINDOW_SIZE
+	return w.bitmap[i/64]&(1<<(i%64)) != 0
+}
+
+func (w *replayWindow) set(counter uint64) {
+	i := counter % REPLAY_WINDOW_SIZE
+	w.bitmap[i/64] |= 1 << (i % 64)
+}
+
+func (w *replayWindow) clear(counter uint64) {
+	i := counter % REPLAY_WINDOW_SIZE
+	w.bitmap[i/64] &^= 1 << (i % 64)
+}
+
+func randomCounter() uint64 {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return binary.BigEndian.Uint64(b) | (1 << 63)
+}
@@ -0,0 +1,146 @@
+package rudp
+
+import "bytes"
+import "testing"
+import "rudpproto"
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+
+	for _, c := range []uint64{5, 3, 7, 4, 6} {
+		if !w.Check(c) {
+			t.Fatalf("counter %d rejected", c)
+		}
+	}
+	for _, c := range []uint64{3, 5, 7} {
+		if w.Check(c) {
+			t.Fatalf("replayed counter %d accepted", c)
+		}
+	}
+
+	if !w.Check(7 + REPLAY_WINDOW_SIZE) {
+		t.Fatal("counter ahead of the window rejected")
+	}
+	if w.Check(7) {
+		t.Fatal("counter behind the window accepted")
+	}
+	if !w.Check(8 + REPLAY_WINDOW_SIZE/2) {
+		t.Fatal("counter inside the window rejected")
+	}
+}
+
+func TestSessionKeyFreshPerRegistration(t *testing.T) {
+	var h RudpHandshake
+	h.Init()
+
+	var reg rudpmsg.RudpMsgReg
+	state, err := h.InitRegister(1, &reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same registration accepted twice, as when it is replayed, must not
+	// give the same key.
+	var rs1, rs2 rudpmsg.RudpMsgRegRs
+	key1, ok1 := h.AcceptRegister(1, &reg, &rs1)
+	key2, ok2 := h.AcceptRegister(1, &reg, &rs2)
+	if !ok1 || !ok2 || key1 == nil || bytes.Equal(key1, key2) {
+		t.Fatalf("replayed registration reuses the session key")
+	}
+
+	key, ok := h.FinishRegister(1, state, &rs1)
+	if !ok || !bytes.Equal(key, key1) {
+		t.Fatal("peers derived different session keys")
+	}
+}
+
+func TestAeadReplayRejected(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	p := newTestPair(t, func(r *ReliableUdp) {
+		r.SetEncryptMode(ENCRYPT_MODE_AEAD)
+		r.SetEncryptKey(key)
+	})
+
+	p.srvCodec.arm(nil, 0)
+	if err := p.cli.SendData(p.sid, []byte("once")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 1
+	})
+
+	p.srvCodec.lock.Lock()
+	packet := p.srvCodec.packets[0]
+	p.srvCodec.lock.Unlock()
+
+	encrypt := p.srv.GetEncrypt()
+	encrypt.lock.Lock()
+	sessionKey := len(encrypt.cipherMap[p.sid].key) > 0
+	encrypt.lock.Unlock()
+	if !sessionKey {
+		t.Fatal("session still on the initial key")
+	}
+
+	if data, _ := encrypt.OpenPacket(packet); data != nil {
+		t.Fatal("replayed packet accepted")
+	}
+}
+
+func TestInitialCipherCached(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	var client, server RudpEncrypt
+	for _, e := range []*RudpEncrypt{&client, &server} {
+		e.Init()
+		e.SetMode(ENCRYPT_MODE_AEAD)
+		if err := e.SetKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client.AddSession(1, true)
+	client.AddSession(2, true)
+
+	if data, _ := server.OpenPacket(client.EncodePacket(1, []byte("reg"))); string(data) != "reg" {
+		t.Fatal("initial packet rejected")
+	}
+
+	aead := server.initiatorAead
+	if aead == nil {
+		t.Fatal("initial cipher not cached")
+	}
+
+	packet := client.EncodePacket(2, []byte("reg"))
+	if data, _ := server.OpenPacket(packet); string(data) != "reg" {
+		t.Fatal("initial packet of another session rejected")
+	}
+	if server.initiatorAead != aead || len(server.cipherMap) != 0 {
+		t.Fatal("initial cipher rebuilt for an unknown session")
+	}
+
+	// The session id is authenticated, a packet can't be moved to another
+	// session.
+	packet[7] = 3
+	if data, _ := server.OpenPacket(packet); data != nil {
+		t.Fatal("packet moved to another session accepted")
+	}
+}
+
+func TestSessionKeyIgnoredOutsideAead(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, mode := range []int{ENCRYPT_MODE_PLAIN, ENCRYPT_MODE_LEGACY} {
+		var e RudpEncrypt
+		e.Init()
+		e.SetMode(mode)
+		e.AddSession(1, true)
+
+		if err := e.SetSessionKey(1, key); err != nil {
+			t.Fatal(err)
+		}
+		c := e.cipherMap[1]
+		if len(c.key) > 0 || c.initialAead != nil || e.initiatorAead != nil {
+			t.Fatalf("mode %d installed a session key", mode)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package rudp
+
+import "errors"
+
+var (
+	ErrUnknownSession  = errors.New("rudp: unknown session")
+	ErrSessionClosed   = errors.New("rudp: session closed")
+	ErrWindowFull      = errors.New("rudp: send window full")
+	ErrMessageTooLarge = errors.New("rudp: message too large")
+	ErrEncodeFailed    = errors.New("rudp: encode failed")
+	ErrUnknownStream   = errors.New("rudp: unknown stream")
+	ErrStreamClosed    = errors.New("rudp: stream closed")
+	ErrTooManyStreams  = errors.New("rudp: too many streams")
+	ErrNotEstablished  = errors.New("rudp: session not established")
+
+	ErrSessionCreateFailed = errors.New("rudp: session create failed")
+	ErrListenerExists      = errors.New("rudp: listener exists")
+	ErrInvalidArgument     = errors.New("rudp: invalid argument")
+	ErrCloseTimeout        = errors.New("rudp: close timeout")
+)
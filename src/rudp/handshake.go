@@ -0,0 +1,297 @@
+package rudp
+
+import "sync"
+import "crypto/ecdh"
+import "crypto/hmac"
+import "crypto/rand"
+import "encoding/binary"
+import "rudpproto"
+import "github.com/woodywanghg/gofclog"
+
+const (
+	REG_RS_CODE_OK           = 0
+	REG_RS_CODE_EXIST        = 10001
+	REG_RS_CODE_AUTH_FAILED  = 10002
+	REG_RS_CODE_BACKLOG_FULL = 10003
+)
+
+const (
+	HANDSHAKE_NONCE_LEN = 16
+)
+
+// RudpHandshake carries the optional key agreement of session registration.
+// With key exchange enabled both sides send an ephemeral X25519 public key,
+// with a PSK the registration is authenticated by an HMAC over the handshake.
+// Either way the agreed traffic key is installed as the session key. Both
+// sides also send a fresh nonce that goes into the session key and the
+// HMAC, so a session never reuses the key of an earlier one with the same
+// id, even when its registration is replayed.
+//
+// Key exchange alone is not authenticated: anyone on the path can run it in
+// the middle. Combine it with a PSK to authenticate the peers.
+type RudpHandshake struct {
+	lock        sync.Mutex
+	keyExchange bool
+	pskId       []byte
+	pskMap      map[string][]byte
+}
+
+func (h *RudpHandshake) Init() {
+	h.keyExchange = false
+	h.pskId = make([]byte, 0)
+	h.pskMap = make(map[string][]byte, 0)
+}
+
+func (h *RudpHandshake) SetKeyExchange(enable bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.keyExchange = enable
+}
+
+func (h *RudpHandshake) IsKeyExchange() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.keyExchange
+}
+
+// SetPsk makes identity the key presented when creating sessions and adds
+// it to the keys accepted from peers. Once a PSK is set, registrations
+// without a valid PSK are rejected.
+func (h *RudpHandshake) SetPsk(identity string, key []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.pskId = []byte(identity)
+	h.pskMap[identity] = append([]byte{}, key...)
+}
+
+// IsAuthenticated reports whether registration responses carry a MAC. Then
+// a response without one, such as a failure code, may be forged.
+func (h *RudpHandshake) IsAuthenticated() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return len(h.pskId) > 0
+}
+
+func (h *RudpHandshake) isRequired() bool {
+	return h.keyExchange || len(h.pskMap) > 0
+}
+
+// HandshakeState is what the creating side keeps between its registration
+// and the response.
+type HandshakeState struct {
+	privKey *ecdh.PrivateKey
+	nonce   []byte
+}
+
+func (h *RudpHandshake) InitRegister(sid int64, msg *rudpmsg.RudpMsgReg) (*HandshakeState, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	state := new(HandshakeState)
+
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	state.nonce = nonce
+	msg.Nonce = nonce
+
+	if h.keyExchange {
+		state.privKey, err = ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		msg.Pubkey = state.privKey.PublicKey().Bytes()
+	}
+
+	if len(h.pskId) > 0 {
+		psk := h.pskMap[string(h.pskId)]
+		msg.Pskid = h.pskId
+		msg.Mac = handshakeMac(psk, "rudp reg", sid, msg.Pubkey, nil, nonce, nil)
+	}
+
+	return state, nil
+}
+
+// AcceptRegister checks a registration and fills in the response. It returns
+// the traffic key to install, nil when the peer registered without a
+// handshake, and false when the peer failed authentication.
+func (h *RudpHandshake) AcceptRegister(sid int64, reg *rudpmsg.RudpMsgReg, rs *rudpmsg.RudpMsgRegRs) ([]byte, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	clientPub := reg.GetPubkey()
+	clientNonce := reg.GetNonce()
+
+	// Peers that send no nonce keep the key they registered with.
+	if len(clientNonce) > 0 {
+		var err error
+		rs.Nonce, err = newNonce()
+		if err != nil {
+			fclog.ERROR("Generate nonce error! err=%s", err.Error())
+			return nil, false
+		}
+	}
+
+	if !h.isRequired() && len(clientPub) == 0 && len(reg.GetPskid()) == 0 {
+		if len(clientNonce) == 0 {
+			return nil, true
+		}
+		return trafficKey(nil, sid, nil, clientNonce, rs.Nonce), true
+	}
+
+	if h.keyExchange && len(clientPub) == 0 {
+		fclog.ERROR("Register without key exchange sid=%d", sid)
+		return nil, false
+	}
+
+	// Without a fresh nonce of ours in the key a replayed registration would
+	// derive the key of the session it was captured from.
+	if len(clientNonce) != HANDSHAKE_NONCE_LEN {
+		fclog.ERROR("Register without nonce sid=%d", sid)
+		return nil, false
+	}
+
+	psk := make([]byte, 0)
+	if len(h.pskMap) > 0 {
+		var exist bool
+		psk, exist = h.pskMap[string(reg.GetPskid())]
+		if !exist {
+			fclog.ERROR("Register with unknown psk sid=%d", sid)
+			return nil, false
+		}
+
+		mac := handshakeMac(psk, "rudp reg", sid, clientPub, nil, clientNonce, nil)
+		if !hmac.Equal(mac, reg.GetMac()) {
+			fclog.ERROR("Register psk authentication failed sid=%d", sid)
+			return nil, false
+		}
+	}
+
+	var shared []byte = nil
+
+	if len(clientPub) > 0 {
+		peerKey, err := ecdh.X25519().NewPublicKey(clientPub)
+		if err != nil {
+			fclog.ERROR("Invalid register public key sid=%d", sid)
+			return nil, false
+		}
+
+		privKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			fclog.ERROR("Generate key error! err=%s", err.Error())
+			return nil, false
+		}
+
+		shared, err = privKey.ECDH(peerKey)
+		if err != nil {
+			fclog.ERROR("Key exchange error! sid=%d err=%s", sid, err.Error())
+			return nil, false
+		}
+
+		rs.Pubkey = privKey.PublicKey().Bytes()
+	}
+
+	if len(psk) > 0 {
+		rs.Mac = handshakeMac(psk, "rudp reg rs", sid, clientPub, rs.Pubkey, clientNonce, rs.Nonce)
+	}
+
+	return trafficKey(psk, sid, shared, clientNonce, rs.Nonce), true
+}
+
+// FinishRegister verifies the registration response on the creating side and
+// returns the traffic key, nil when no handshake was used.
+func (h *RudpHandshake) FinishRegister(sid int64, state *HandshakeState, rs *rudpmsg.RudpMsgRegRs) ([]byte, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if state == nil {
+		fclog.ERROR("Register response without registration sid=%d", sid)
+		return nil, false
+	}
+
+	// A peer that answers without a nonce didn't use ours either.
+	clientNonce := state.nonce
+	if len(rs.GetNonce()) == 0 {
+		clientNonce = nil
+	}
+
+	privKey := state.privKey
+	if privKey == nil && len(h.pskId) == 0 {
+		if len(clientNonce) == 0 {
+			return nil, true
+		}
+		return trafficKey(nil, sid, nil, clientNonce, rs.GetNonce()), true
+	}
+
+	if len(rs.GetNonce()) != HANDSHAKE_NONCE_LEN {
+		fclog.ERROR("Register response without nonce sid=%d", sid)
+		return nil, false
+	}
+
+	var clientPub []byte = nil
+	var shared []byte = nil
+
+	if privKey != nil {
+		peerKey, err := ecdh.X25519().NewPublicKey(rs.GetPubkey())
+		if err != nil {
+			fclog.ERROR("Invalid register response public key sid=%d", sid)
+			return nil, false
+		}
+
+		shared, err = privKey.ECDH(peerKey)
+		if err != nil {
+			fclog.ERROR("Key exchange error! sid=%d err=%s", sid, err.Error())
+			return nil, false
+		}
+
+		clientPub = privKey.PublicKey().Bytes()
+	}
+
+	psk := make([]byte, 0)
+	if len(h.pskId) > 0 {
+		psk = h.pskMap[string(h.pskId)]
+
+		mac := handshakeMac(psk, "rudp reg rs", sid, clientPub, rs.GetPubkey(), clientNonce, rs.GetNonce())
+		if !hmac.Equal(mac, rs.GetMac()) {
+			fclog.ERROR("Register response psk authentication failed sid=%d", sid)
+			return nil, false
+		}
+	}
+
+	return trafficKey(psk, sid, shared, clientNonce, rs.GetNonce()), true
+}
+
+func handshakeMac(psk []byte, label string, sid int64, clientPub []byte, serverPub []byte, clientNonce []byte, serverNonce []byte) []byte {
+	context := make([]byte, 8, 8+len(clientPub)+len(serverPub)+len(clientNonce)+len(serverNonce))
+	binary.BigEndian.PutUint64(context, uint64(sid))
+	context = append(context, clientPub...)
+	context = append(context, serverPub...)
+	context = append(context, clientNonce...)
+	context = append(context, serverNonce...)
+
+	return deriveKey(psk, label, context)
+}
+
+func trafficKey(psk []byte, sid int64, shared []byte, clientNonce []byte, serverNonce []byte) []byte {
+	context := make([]byte, 8, 8+len(shared)+len(clientNonce)+len(serverNonce))
+	binary.BigEndian.PutUint64(context, uint64(sid))
+	context = append(context, shared...)
+	context = append(context, clientNonce...)
+	context = append(context, serverNonce...)
+
+	return deriveKey(psk, "rudp traffic", context)
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, HANDSHAKE_NONCE_LEN)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
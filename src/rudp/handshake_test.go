@@ -0,0 +1,72 @@
+package rudp
+
+import "bytes"
+import "testing"
+import "github.com/golang/protobuf/proto"
+import "rudpproto"
+
+func newPskHandshake(keyExchange bool) *RudpHandshake {
+	h := new(RudpHandshake)
+	h.Init()
+	h.SetKeyExchange(keyExchange)
+	h.SetPsk("a", []byte("secret-a"))
+	return h
+}
+
+func TestPskReplayedRegisterGetsFreshKey(t *testing.T) {
+	client := newPskHandshake(false)
+	server := newPskHandshake(false)
+
+	var reg rudpmsg.RudpMsgReg
+	state, err := client.InitRegister(1, &reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rs, replayRs rudpmsg.RudpMsgRegRs
+	key, ok := server.AcceptRegister(1, &reg, &rs)
+	if !ok {
+		t.Fatal("registration rejected")
+	}
+	replayKey, ok := server.AcceptRegister(1, &reg, &replayRs)
+	if !ok || bytes.Equal(key, replayKey) {
+		t.Fatal("replayed registration derives the same key")
+	}
+
+	clientKey, ok := client.FinishRegister(1, state, &rs)
+	if !ok || !bytes.Equal(clientKey, key) {
+		t.Fatal("peers derived different keys")
+	}
+}
+
+func TestPskMacCoversNonces(t *testing.T) {
+	client := newPskHandshake(true)
+	server := newPskHandshake(true)
+
+	var reg rudpmsg.RudpMsgReg
+	state, err := client.InitRegister(1, &reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := proto.Clone(&reg).(*rudpmsg.RudpMsgReg)
+	tampered.Nonce = bytes.Repeat([]byte{1}, HANDSHAKE_NONCE_LEN)
+	var rs rudpmsg.RudpMsgRegRs
+	if _, ok := server.AcceptRegister(1, tampered, &rs); ok {
+		t.Fatal("registration with a changed nonce accepted")
+	}
+
+	noNonce := proto.Clone(&reg).(*rudpmsg.RudpMsgReg)
+	noNonce.Nonce = nil
+	if _, ok := server.AcceptRegister(1, noNonce, &rs); ok {
+		t.Fatal("registration without nonce accepted")
+	}
+
+	if _, ok := server.AcceptRegister(1, &reg, &rs); !ok {
+		t.Fatal("registration rejected")
+	}
+	rs.Nonce = bytes.Repeat([]byte{2}, HANDSHAKE_NONCE_LEN)
+	if _, ok := client.FinishRegister(1, state, &rs); ok {
+		t.Fatal("response with a changed nonce accepted")
+	}
+}
@@ -0,0 +1,129 @@
+package rudp
+
+import "net"
+import "sync"
+
+const (
+	ACCEPT_BACKLOG_DEFAULT = 128
+)
+
+// RudpListener accepts the sessions peers register with an endpoint as
+// connections. Registrations are rejected with REG_RS_CODE_BACKLOG_FULL
+// while backlog sessions wait for Accept.
+type RudpListener struct {
+	rudp       *ReliableUdp
+	acceptChan chan *RudpConn
+	closeChan  chan bool
+	closeOnce  sync.Once
+	owner      bool
+}
+
+// Listen creates an endpoint on ip:port and returns a listener for it.
+// Closing the listener closes the endpoint and its connections.
+func Listen(ip string, port int, backlog int) (*RudpListener, error) {
+
+	r := NewReliableUdp()
+
+	err := r.Listen(ip, port)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := r.NewListener(backlog)
+	if err != nil {
+		r.Close(CLOSE_POLICY_ABANDON)
+		return nil, err
+	}
+	l.owner = true
+
+	return l, nil
+}
+
+// NewListener accepts the sessions registered with the endpoint from now on,
+// until the listener is closed.
+func (r *ReliableUdp) NewListener(backlog int) (*RudpListener, error) {
+
+	if backlog <= 0 {
+		backlog = ACCEPT_BACKLOG_DEFAULT
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.listener != nil {
+		return nil, ErrListenerExists
+	}
+
+	l := new(RudpListener)
+	l.rudp = r
+	l.acceptChan = make(chan *RudpConn, backlog)
+	l.closeChan = make(chan bool)
+	l.owner = false
+
+	r.listener = l
+
+	return l, nil
+}
+
+func (l *RudpListener) isFull() bool {
+	return len(l.acceptChan) >= cap(l.acceptChan)
+}
+
+func (l *RudpListener) onAccept(conn *RudpConn) {
+	select {
+	case l.acceptChan <- conn:
+	default:
+	}
+}
+
+func (l *RudpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.acceptChan:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, net.ErrClosed
+	case <-l.rudp.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting sessions and closes those not accepted yet.
+func (l *RudpListener) Close() error {
+
+	closed := false
+
+	l.closeOnce.Do(func() {
+		closed = true
+
+		l.rudp.lock.Lock()
+		if l.rudp.listener == l {
+			l.rudp.listener = nil
+		}
+		l.rudp.lock.Unlock()
+
+		close(l.closeChan)
+	})
+
+	if !closed {
+		return net.ErrClosed
+	}
+
+	// Nobody wrote to the sessions not accepted, they don't wait for acks.
+	for {
+		select {
+		case conn := <-l.acceptChan:
+			conn.close(CLOSE_POLICY_ABANDON)
+		default:
+			if l.owner {
+				return l.rudp.Close(CLOSE_POLICY_ABANDON)
+			}
+			return nil
+		}
+	}
+}
+
+func (l *RudpListener) Addr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP(l.rudp.udpSocket.GetLocalIp()), Port: l.rudp.udpSocket.GetLocalPort()}
+}
+
+var _ net.Listener = (*RudpListener)(nil)
@@ -0,0 +1,127 @@
+package rudp
+
+const (
+	DATAGRAM_SIZE_DEFAULT = 1472
+	DATAGRAM_SIZE_BASE    = 1200
+	DATAGRAM_OVERHEAD     = 96
+)
+
+const (
+	PMTU_PROBE_COUNT        = 3
+	PMTU_SEARCH_STEP        = 16
+	PMTU_RAISE_INTERVAL     = 600 * 1000 * 1000000
+	PMTU_BLACKHOLE_TIMEOUTS = 3
+)
+
+// PmtuSearch is the packetization layer path MTU discovery of a session as
+// in RFC 8899. Padded probes binary search the datagram sizes between the
+// confirmed size and the endpoint maximum, a probe lost PMTU_PROBE_COUNT
+// times lowers the upper bound. Once complete the search is raised again
+// after PMTU_RAISE_INTERVAL.
+type PmtuSearch struct {
+	enabled    bool
+	baseSize   int
+	maxSize    int
+	plpmtu     int
+	low        int
+	high       int
+	probeSize  int
+	probeSeq   int64
+	probeTs    int64
+	probeCount int
+	complete   bool
+	completeTs int64
+}
+
+func (p *PmtuSearch) Init(enabled bool, maxSize int) {
+	p.enabled = enabled
+	p.maxSize = maxSize
+	p.baseSize = DATAGRAM_SIZE_BASE
+	if p.baseSize > maxSize {
+		p.baseSize = maxSize
+	}
+	p.Reset()
+}
+
+// Reset falls back to the base size and searches again, used when the
+// confirmed size stops getting through.
+func (p *PmtuSearch) Reset() {
+	p.plpmtu = p.baseSize
+	p.low = p.baseSize
+	p.high = p.maxSize
+	p.probeSize = 0
+	p.probeCount = 0
+	p.complete = false
+	p.completeTs = 0
+}
+
+func (p *PmtuSearch) GetPlpmtu() int {
+	return p.plpmtu
+}
+
+// NextProbe returns the size of the probe to send at curTs, false when none
+// is due. An outstanding probe counts as lost after timeout.
+func (p *PmtuSearch) NextProbe(curTs int64, timeout int64) (int, bool) {
+
+	if !p.enabled {
+		return 0, false
+	}
+
+	if p.complete {
+		if p.plpmtu >= p.maxSize || curTs-p.completeTs < PMTU_RAISE_INTERVAL {
+			return 0, false
+		}
+		p.complete = false
+		p.low = p.plpmtu
+		p.high = p.maxSize
+	}
+
+	if p.probeSize > 0 {
+		if curTs-p.probeTs < timeout {
+			return 0, false
+		}
+
+		p.probeCount += 1
+		if p.probeCount >= PMTU_PROBE_COUNT {
+			p.high = p.probeSize - 1
+			p.probeSize = 0
+			p.probeCount = 0
+		}
+	}
+
+	if p.high-p.low < PMTU_SEARCH_STEP {
+		p.complete = true
+		p.completeTs = curTs
+		p.probeSize = 0
+		return 0, false
+	}
+
+	if p.probeSize == 0 {
+		p.probeSize = (p.low + p.high + 1) / 2
+	}
+
+	p.probeSeq += 1
+	p.probeTs = curTs
+
+	return p.probeSize, true
+}
+
+func (p *PmtuSearch) GetProbeSeq() int64 {
+	return p.probeSeq
+}
+
+// OnProbeAck confirms the size of an acknowledged probe and returns whether
+// the path MTU grew.
+func (p *PmtuSearch) OnProbeAck(seq int64, size int) bool {
+
+	if p.probeSize == 0 || seq != p.probeSeq || size != p.probeSize {
+		return false
+	}
+
+	p.low = size
+	p.plpmtu = size
+	p.probeSize = 0
+	p.probeCount = 0
+
+	return true
+}
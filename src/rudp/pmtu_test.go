@@ -0,0 +1,116 @@
+package rudp
+
+import "testing"
+
+func TestPmtuSearch(t *testing.T) {
+	const timeout = int64(100)
+
+	var p PmtuSearch
+	p.Init(true, DATAGRAM_SIZE_DEFAULT)
+	if p.GetPlpmtu() != DATAGRAM_SIZE_BASE {
+		t.Fatalf("plpmtu=%d", p.GetPlpmtu())
+	}
+
+	curTs := int64(1)
+	probe := func(want int) {
+		t.Helper()
+		size, ok := p.NextProbe(curTs, timeout)
+		if !ok || size != want {
+			t.Fatalf("probe size=%d ok=%v, want %d", size, ok, want)
+		}
+	}
+
+	// Binary search between the base and the endpoint maximum.
+	probe(1336)
+	if _, ok := p.NextProbe(curTs, timeout); ok {
+		t.Fatal("probe sent before the outstanding one timed out")
+	}
+	if p.OnProbeAck(p.GetProbeSeq()-1, 1336) || p.OnProbeAck(p.GetProbeSeq(), 1200) {
+		t.Fatal("stale probe ack accepted")
+	}
+	if !p.OnProbeAck(p.GetProbeSeq(), 1336) || p.GetPlpmtu() != 1336 {
+		t.Fatalf("probe ack plpmtu=%d", p.GetPlpmtu())
+	}
+
+	// A probe lost PMTU_PROBE_COUNT times lowers the upper bound.
+	probe(1404)
+	for i := 1; i < PMTU_PROBE_COUNT; i++ {
+		curTs += timeout
+		probe(1404)
+	}
+	curTs += timeout
+	probe(1370)
+	if p.GetPlpmtu() != 1336 {
+		t.Fatalf("lost probe changed plpmtu=%d", p.GetPlpmtu())
+	}
+
+	ack := func(size int) {
+		t.Helper()
+		if !p.OnProbeAck(p.GetProbeSeq(), size) {
+			t.Fatalf("probe of %d not acked", size)
+		}
+	}
+	ack(1370)
+	probe(1387)
+	ack(1387)
+	probe(1395)
+	ack(1395)
+
+	// Done once the bounds are closer than a step, raised again later.
+	if _, ok := p.NextProbe(curTs, timeout); ok || p.GetPlpmtu() != 1395 {
+		t.Fatalf("search not complete plpmtu=%d", p.GetPlpmtu())
+	}
+	if _, ok := p.NextProbe(curTs+PMTU_RAISE_INTERVAL-1, timeout); ok {
+		t.Fatal("search raised before its interval")
+	}
+	curTs += PMTU_RAISE_INTERVAL
+	probe(1434)
+}
+
+func TestPmtuDisabled(t *testing.T) {
+	var p PmtuSearch
+	p.Init(false, DATAGRAM_SIZE_DEFAULT)
+
+	if _, ok := p.NextProbe(1, 0); ok {
+		t.Fatal("probe sent with discovery disabled")
+	}
+	if p.GetPlpmtu() != DATAGRAM_SIZE_BASE {
+		t.Fatalf("plpmtu=%d", p.GetPlpmtu())
+	}
+}
+
+func TestPmtuBlackHole(t *testing.T) {
+	s := newTestSession()
+	s.pmtu.plpmtu = 1400
+	if s.GetFragmentSize() != 1400-DATAGRAM_OVERHEAD || s.GetMaxPayload() != 1400-DATAGRAM_OVERHEAD {
+		t.Fatalf("fragment size=%d max payload=%d", s.GetFragmentSize(), s.GetMaxPayload())
+	}
+
+	curTs := int64(1)
+	for i := 0; i < PMTU_BLACKHOLE_TIMEOUTS; i++ {
+		curTs += s.GetRto()
+		s.OnRetransTimeout(curTs)
+	}
+
+	if s.GetPmtu() != DATAGRAM_SIZE_BASE || s.GetMaxPayload() != DATAGRAM_SIZE_BASE-DATAGRAM_OVERHEAD {
+		t.Fatalf("pmtu=%d max payload=%d after a black hole", s.GetPmtu(), s.GetMaxPayload())
+	}
+}
+
+func TestPayloadLimitedByPmtu(t *testing.T) {
+	p := newTestPair(t, func(r *ReliableUdp) {
+		r.SetPmtuDiscovery(false)
+	})
+
+	// The endpoint takes larger datagrams, the path is not known to.
+	payload := make([]byte, DATAGRAM_SIZE_BASE-DATAGRAM_OVERHEAD+1)
+	if err := p.cli.SendData(p.sid, payload); err != ErrMessageTooLarge {
+		t.Fatalf("send above the path MTU err=%v", err)
+	}
+	if err := p.cli.SendData(p.sid, payload[1:]); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 1
+	})
+}
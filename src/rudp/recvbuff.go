@@ -1,108 +1,331 @@
 package rudp
 
 import "time"
-import "sort"
-import "math"
 import "github.com/woodywanghg/gofclog"
 
 type RecvBuffItem struct {
-	data []byte
-	ts   int64
+	data      []byte
+	ts        int64
+	frag      int32
+	total     int32
+	delivered bool
 }
 
+// RecvBuff holds the packets received ahead of delivery in a ring of slots,
+// slot head holding nextSeq and the others the sequences after it. A bitmap
+// marks the filled slots. Packets further ahead than the ring are dropped,
+// which bounds what a peer can make us buffer. Data delivered out of order
+// waits in ready, bounded by the ring size too. A ring may start smaller
+// and be resized up to limit slots.
 type RecvBuff struct {
-	seq        int64
-	seqMap     map[int64]*RecvBuffItem
+	items      []RecvBuffItem
+	limit      int
+	bitmap     []uint64
+	head       int
+	count      int
 	nextSeq    int64
+	ready      [][]byte
 	udpSession *UdpSession
-	seqInts    []int
 }
 
-func (s *RecvBuff) Init(udpSession *UdpSession) {
-	s.seq = 0
+// Init sets up an empty buffer of size slots, the largest span of
+// out of order packets accepted.
+func (s *RecvBuff) Init(udpSession *UdpSession, size int) {
+	if size < 0 {
+		size = 0
+	}
+
+	s.items = make([]RecvBuffItem, size)
+	s.bitmap = make([]uint64, (size+63)/64)
+	s.limit = size
+	s.head = 0
+	s.count = 0
 	s.nextSeq = 0
+	s.ready = make([][]byte, 0)
 	s.udpSession = udpSession
-	s.seqMap = make(map[int64]*RecvBuffItem, 100)
 }
 
-func (s *RecvBuff) Insert(seq int64, b []byte) bool {
+// InitLazy sets up a buffer without slots that Resize grows up to limit
+// slots.
+func (s *RecvBuff) InitLazy(udpSession *UdpSession, limit int) {
+	s.Init(udpSession, 0)
+	if limit > 0 {
+		s.limit = limit
+	}
+}
+
+// Resize moves the packets held to a ring of size slots. It fails when size
+// exceeds the limit or a packet held doesn't fit.
+func (s *RecvBuff) Resize(size int) bool {
+
+	if size < 0 || size > s.limit {
+		return false
+	}
+
+	for offset := size; offset < len(s.items); offset++ {
+		if s.has(offset) {
+			return false
+		}
+	}
+
+	items := make([]RecvBuffItem, size)
+	bitmap := make([]uint64, (size+63)/64)
+	for offset := 0; offset < size && offset < len(s.items); offset++ {
+		if s.has(offset) {
+			items[offset] = s.items[s.slot(offset)]
+			bitmap[offset/64] |= 1 << uint(offset%64)
+		}
+	}
+
+	s.items = items
+	s.bitmap = bitmap
+	s.head = 0
+
+	return true
+}
+
+// GetSize returns the number of slots of the ring.
+func (s *RecvBuff) GetSize() int {
+	return len(s.items)
+}
+
+func (s *RecvBuff) slot(offset int) int {
+	return (s.head + offset) % len(s.items)
+}
+
+func (s *RecvBuff) has(offset int) bool {
+	if offset >= len(s.items) {
+		return false
+	}
+	i := s.slot(offset)
+	return s.bitmap[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (s *RecvBuff) Insert(seq int64, b []byte, frag int32, total int32) bool {
+
+	if !s.accept(seq) {
+		return false
+	}
+
+	i := s.slot(int(s.udpSession.GetSeqSpace().Distance(s.nextSeq, seq)))
+	s.items[i] = RecvBuffItem{data: b, ts: time.Now().UnixNano(), frag: frag, total: total}
+	s.bitmap[i/64] |= 1 << uint(i%64)
+	s.count += 1
+
+	return true
+}
+
+// InsertUnordered records seq as received and makes b ready at once instead
+// of waiting for the data before it.
+func (s *RecvBuff) InsertUnordered(seq int64, b []byte) bool {
+
+	if len(s.ready) >= len(s.items) {
+		fclog.ERROR("Receive queue full! seq=%d", seq)
+		return false
+	}
+
+	if !s.InsertDelivered(seq) {
+		return false
+	}
+
+	s.ready = append(s.ready, b)
+
+	return true
+}
+
+// InsertDelivered records seq as received, its data being delivered
+// elsewhere.
+func (s *RecvBuff) InsertDelivered(seq int64) bool {
+
+	if !s.accept(seq) {
+		return false
+	}
+
+	i := s.slot(int(s.udpSession.GetSeqSpace().Distance(s.nextSeq, seq)))
+	s.items[i] = RecvBuffItem{ts: time.Now().UnixNano(), delivered: true}
+	s.bitmap[i/64] |= 1 << uint(i%64)
+	s.count += 1
+
+	return true
+}
 
-	if seq < s.nextSeq && math.Abs(float64(seq-s.nextSeq)) < (SEQ_MAX_INDEX-3000)*1.0 {
+// Push makes b ready without a sequence, for unreliable data. It is dropped
+// when the queue is full.
+func (s *RecvBuff) Push(b []byte) bool {
+
+	if len(s.ready) >= len(s.items) {
+		fclog.ERROR("Receive queue full! drop datagram len=%d", len(b))
+		return false
+	}
+
+	s.ready = append(s.ready, b)
+	return true
+}
+
+// accept checks that seq falls in the receive window and was not received
+// yet.
+func (s *RecvBuff) accept(seq int64) bool {
+
+	space := s.udpSession.GetSeqSpace()
+
+	if seq < 0 || seq >= int64(space) {
+		fclog.ERROR("Sequence out of range! seq=%d", seq)
+		return false
+	}
+
+	// Anything not within half the space ahead was already delivered.
+	distance := space.Distance(s.nextSeq, seq)
+	if distance >= space.Half() {
 		fclog.ERROR("Find invalid timeout packet! seq=%d", seq)
 		return false
 	}
 
-	_, have := s.seqMap[seq]
-	if have {
+	if distance >= int64(len(s.items)) {
+		fclog.ERROR("Packet beyond receive window! seq=%d next=%d", seq, s.nextSeq)
+		return false
+	}
+
+	if s.has(int(distance)) {
 		fclog.INFO("Find duplicate key. return!")
 		return false
 	}
 
-	item := new(RecvBuffItem)
-	item.data = b
-	item.ts = time.Now().UnixNano()
+	return true
+}
 
-	s.seqMap[seq] = item
-	s.seqInts = append(s.seqInts, int(seq))
-	sort.Ints(s.seqInts)
-	fclog.DEBUG("Sort ints=%v map=%v", s.seqInts, s.seqMap)
+// IsReceived reports whether seq was received already, delivered or not.
+func (s *RecvBuff) IsReceived(seq int64) bool {
 
-	return true
+	space := s.udpSession.GetSeqSpace()
+
+	if seq < 0 || seq >= int64(space) {
+		return false
+	}
+
+	distance := space.Distance(s.nextSeq, seq)
+	if distance >= space.Half() {
+		return true
+	}
+
+	return s.has(int(distance))
+}
+
+// GetData returns the next data in sequence, after the data ready out of
+// order. The fragments of a message are returned together once all of them
+// arrived, message reports whether the data was sent as a message.
+func (s *RecvBuff) GetData() ([]byte, bool, bool) {
+
+	if len(s.ready) > 0 {
+		b := s.ready[0]
+		s.ready[0] = nil
+		s.ready = s.ready[1:]
+		return b, false, true
+	}
+
+	for {
+		if !s.has(0) {
+			return nil, false, false
+		}
+
+		item := s.items[s.head]
+		if item.delivered {
+			s.advance(1)
+			continue
+		}
+
+		if item.total == 0 {
+			s.advance(1)
+			return item.data, false, true
+		}
+
+		data, count, ok := s.assemble(&item)
+		if count == 0 {
+			return nil, false, false
+		}
+
+		s.advance(count)
+
+		if ok {
+			return data, true, true
+		}
+
+		fclog.ERROR("Drop invalid message fragments count=%d", count)
+	}
 }
 
-func (s *RecvBuff) GetData() ([]byte, bool) {
+// assemble joins the fragments of the message starting at the head of the
+// buffer. It returns how many packets it used, 0 while fragments are
+// missing, and false when they don't form a valid message and have to be
+// dropped.
+func (s *RecvBuff) assemble(head *RecvBuffItem) ([]byte, int, bool) {
 
-	var data []byte
+	maxSize := s.udpSession.GetMaxMessageSize()
 
-	if len(s.seqInts) < 1 {
-		return data, false
+	if head.frag != 0 || head.total < 0 || int(head.total) > maxSize+1 || int(head.total) > s.limit {
+		return nil, 1, false
 	}
 
-	b, have := s.seqMap[s.nextSeq]
-	fclog.DEBUG("sort int have=%v map=%v", have, s.seqMap)
+	size := 0
+	for i := 0; i < int(head.total); i++ {
+		if !s.has(i) {
+			return nil, 0, false
+		}
 
-	if have {
-		fclog.DEBUG("Find index packet seq=%d", s.nextSeq)
-		delete(s.seqMap, s.nextSeq)
-		s.nextSeq = (s.nextSeq + 1) % SEQ_MAX_INDEX
-		if len(s.seqInts) > 1 {
-			s.seqInts = s.seqInts[1:]
-		} else if len(s.seqInts) == 1 {
-			s.seqInts = make([]int, 0)
+		item := &s.items[s.slot(i)]
+		if item.frag != int32(i) || item.total != head.total {
+			return nil, i, false
 		}
 
-		return b.data, true
+		size += len(item.data)
+		if size > maxSize {
+			return nil, i + 1, false
+		}
 	}
 
-	return data, false
-}
+	data := make([]byte, 0, size)
+	for i := 0; i < int(head.total); i++ {
+		data = append(data, s.items[s.slot(i)].data...)
+	}
 
-func (s *RecvBuff) GetTimeoutData(curTs int64, timeoutSec int64) ([]byte, bool) {
+	return data, int(head.total), true
+}
 
-	var data []byte
+// advance moves nextSeq n sequences ahead, releasing the slots passed.
+func (s *RecvBuff) advance(n int) {
 
-	if len(s.seqInts) < 1 {
-		return data, false
+	for k := 0; k < n; k++ {
+		if s.has(0) {
+			s.items[s.head] = RecvBuffItem{}
+			s.bitmap[s.head/64] &^= 1 << uint(s.head%64)
+			s.count -= 1
+		}
+		s.head = (s.head + 1) % len(s.items)
 	}
 
-	v := int64(s.seqInts[0])
-	b, have := s.seqMap[v]
+	s.nextSeq = s.udpSession.GetSeqSpace().Add(s.nextSeq, int64(n))
+}
+
+// GetAckState returns the next sequence not yet received in order and a
+// bitmap of the received sequences after it.
+func (s *RecvBuff) GetAckState() (int64, uint64) {
 
-	if have {
-		if curTs-b.ts > timeoutSec {
-			fclog.DEBUG("Find invalid timeout packet seq=%d", s.nextSeq)
-			delete(s.seqMap, s.nextSeq)
-			s.nextSeq = (v + 1) % SEQ_MAX_INDEX
+	space := s.udpSession.GetSeqSpace()
 
-			if len(s.seqInts) > 1 {
-				s.seqInts = s.seqInts[1:]
-			} else if len(s.seqInts) == 1 {
-				s.seqInts = make([]int, 0)
-			}
+	offset := 0
+	for s.has(offset) {
+		offset += 1
+	}
 
-			return b.data, true
+	var sack uint64 = 0
+	for i := 0; i < ACK_SACK_BITS; i++ {
+		if s.has(offset + 1 + i) {
+			sack |= 1 << uint(i)
 		}
 	}
 
-	return data, false
+	return space.Add(s.nextSeq, int64(offset)), sack
+}
+
+func (s *RecvBuff) GetLength() int {
+	return s.count + len(s.ready)
 }
@@ -9,40 +9,103 @@ import "net/http"
 import "time"
 import "sync"
 import "encoding/json"
+import "sync/atomic"
+import "os"
 
 const (
 	UDP_SESSION_RS_OK  = 0
 	UDP_SESSION_RS_ERR = 1
 )
 
+const (
+	UDP_SESSION_ERR_PEER_TIMEOUT = 1
+)
+
+const (
+	CLOSE_POLICY_ABANDON = 0
+	CLOSE_POLICY_FLUSH   = 1
+)
+
+const (
+	CLOSE_REASON_NORMAL   = 0
+	CLOSE_REASON_RESET    = 1
+	CLOSE_REASON_SHUTDOWN = 2
+	CLOSE_REASON_TIMEOUT  = 3
+)
+
 type StatItem struct {
-	SessionId          int `json:"sessionid"`
-	Lossrate           int `json:"Lossrate"`
-	Retransmissionrate int `json:"retransmissionrate"`
+	SessionId          int   `json:"sessionid"`
+	Lossrate           int   `json:"Lossrate"`
+	Retransmissionrate int   `json:"retransmissionrate"`
+	Srtt               int64 `json:"srtt"`
+	Rto                int64 `json:"rto"`
+	Cwnd               int   `json:"cwnd"`
+	Pmtu               int   `json:"pmtu"`
 }
 
 type StatInfo struct {
 	Count    int        `json:"count"`
+	Corrupt  int64      `json:"corrupt"`
 	Sessions []StatItem `json:"sessions"`
 }
 
 type ReliableUdp struct {
-	encrypt     RudpEncrypt
-	udpSocket   *udpsocket.UdpSocket
-	lock        sync.Mutex
-	sessionMap  map[int64]*UdpSession
-	udpInter    RudpInter
-	readChan    chan bool
-	readTimeOut int
-	statData    []byte
+	encrypt         RudpEncrypt
+	codec           PacketCodec
+	codecLock       sync.RWMutex
+	checksum        int
+	seqBits         int
+	statCorrupt     int64
+	handshake       RudpHandshake
+	udpSocket       *udpsocket.UdpSocket
+	lock            sync.Mutex
+	sessionMap      map[int64]*UdpSession
+	timer           RetransTimer
+	connMap         map[int64]*RudpConn
+	dialTimeout     int64
+	listener        *RudpListener
+	maxDatagramSize int
+	pmtuDiscovery   bool
+	maxMessageSize  int
+	sendCond        *sync.Cond
+	windowPolicy    int
+	recvWindow      int
+	congestionAlgo  int
+	deliveryMode    int
+	maxStreams      int
+	udpInter        RudpInter
+	events          []func(inter RudpInter)
+	readChan        chan bool
+	closeChan       chan bool
+	closeOnce       sync.Once
+	closeTimeout    int64
+	pingInterval    int64
+	idleTimeout     int64
+	ackDelay        int64
+	wg              sync.WaitGroup
+	statLock        sync.Mutex
+	statData        []byte
+	statServer      *http.Server
 }
 
 var rudp *ReliableUdp = nil
+var rudpOnce sync.Once
+
+// NewReliableUdp returns an independent endpoint with its own socket,
+// sessions and worker goroutines.
+func NewReliableUdp() *ReliableUdp {
+	r := new(ReliableUdp)
+	r.Init()
+
+	return r
+}
 
+// GetReliableUdp returns the process wide endpoint. It is kept for
+// compatibility, new code should use NewReliableUdp.
 func GetReliableUdp() *ReliableUdp {
-	if rudp == nil {
-		rudp = new(ReliableUdp)
-	}
+	rudpOnce.Do(func() {
+		rudp = NewReliableUdp()
+	})
 
 	return rudp
 }
@@ -51,27 +114,60 @@ func (r *ReliableUdp) Init() {
 
 	r.udpSocket = nil
 	r.encrypt.Init()
+	r.codec = &r.encrypt
+	r.checksum = CHECKSUM_NONE
+	r.seqBits = SEQ_BITS_16
+	r.statCorrupt = 0
+	r.handshake.Init()
 	r.sessionMap = make(map[int64]*UdpSession, 0)
+	r.timer.Init()
+	r.connMap = make(map[int64]*RudpConn, 0)
+	r.dialTimeout = CONN_DIAL_TIMEOUT
+	r.maxDatagramSize = DATAGRAM_SIZE_DEFAULT
+	r.pmtuDiscovery = true
+	r.maxMessageSize = MESSAGE_SIZE_MAX_DEFAULT
+	r.sendCond = sync.NewCond(&r.lock)
+	r.windowPolicy = WINDOW_POLICY_QUEUE
+	r.recvWindow = RECV_WINDOW_DEFAULT
+	r.congestionAlgo = CONGESTION_NEWRENO
+	r.deliveryMode = DELIVERY_RELIABLE_ORDERED
+	r.maxStreams = STREAM_MAX_DEFAULT
+	r.udpInter = new(RudpInterBase)
+	r.events = nil
 	r.readChan = make(chan bool)
-	r.readTimeOut = 2
+	r.closeChan = make(chan bool)
+	r.closeTimeout = 5000 * 1000000
+	r.pingInterval = 0
+	r.idleTimeout = 0
+	r.ackDelay = ACK_DELAY_DEFAULT
+	r.statData = []byte(`{"count":0, "corrupt":0, "sessions":[]}`)
 }
 
-func (r *ReliableUdp) SetUdpInterface(udpInter RudpInter) {
+func (r *ReliableUdp) SetUdpInterface(udpInter RudpInter) error {
+	if udpInter == nil {
+		fclog.ERROR("SetUdpInterface error! interface is nil")
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
 	r.udpInter = udpInter
+	return nil
 }
 
 func (r *ReliableUdp) Listen(ip string, port int) error {
 
 	r.udpSocket = new(udpsocket.UdpSocket)
 	r.udpSocket.SetUdpReceiver(r)
+	r.udpSocket.SetRecvBufferSize(r.maxDatagramSize)
 	err := r.udpSocket.Listen(ip, port)
 	if err != nil {
 		fclog.ERROR("ReliableUdp init error! err=%s", err.Error())
 		return err
 	}
 
-	go r.sessionRetransmissionCheck()
-	go r.sessionReadCheck()
+	r.startWorker()
 
 	return nil
 }
@@ -79,33 +175,65 @@ func (r *ReliableUdp) Listen(ip string, port int) error {
 func (r *ReliableUdp) DialUDP(ip string, port int) error {
 	r.udpSocket = new(udpsocket.UdpSocket)
 	r.udpSocket.SetUdpReceiver(r)
+	r.udpSocket.SetRecvBufferSize(r.maxDatagramSize)
 	err := r.udpSocket.DialUDP(ip, port)
 	if err != nil {
 		fclog.ERROR("ReliableUdp dial udp error!")
 		return err
 	}
 
-	go r.sessionRetransmissionCheck()
-	go r.sessionReadCheck()
+	r.startWorker()
 
 	return nil
 }
 
+func (r *ReliableUdp) startWorker() {
+	r.wg.Add(4)
+	go r.sessionRetransmissionCheck()
+	go r.sessionReadCheck()
+	go r.sessionKeepaliveCheck()
+	go r.sessionAckCheck()
+}
+
 func (r *ReliableUdp) OnUdpRecv(b []byte, bLen int, ip string, port int) {
 	tempBuf := b[0:bLen]
 	fclog.DEBUG("Recv data=%d byte=%v", bLen, tempBuf)
 
-	if !r.encrypt.IsValidPacket(tempBuf) {
+	if r.getChecksum() != CHECKSUM_NONE {
+		var ok bool
+		tempBuf, ok = verifyChecksum(tempBuf)
+		if !ok {
+			atomic.AddInt64(&r.statCorrupt, 1)
+			fclog.ERROR("Packet checksum error! addr=%s:%d len=%d", ip, port, bLen)
+			return
+		}
+	}
+
+	codec := r.getCodec()
+	if !codec.IsValidPacket(tempBuf) {
 		fclog.ERROR("Invalid packet")
 		return
 	}
 
-	packetData := r.encrypt.GetPacketData(tempBuf)
+	var packetData []byte
+	initial := false
+
+	handshakeCodec, ok := codec.(HandshakeCodec)
+	if ok {
+		packetData, initial = handshakeCodec.OpenPacket(tempBuf)
+	} else {
+		packetData = codec.GetPacketData(tempBuf)
+	}
+
+	if packetData == nil {
+		fclog.ERROR("Invalid packet data")
+		return
+	}
 
-	r.decodePacket(packetData, ip, port)
+	r.decodePacket(packetData, ip, port, initial)
 }
 
-func (r *ReliableUdp) decodePacket(b []byte, ip string, port int) {
+func (r *ReliableUdp) decodePacket(b []byte, ip string, port int, initial bool) {
 
 	var msg rudpmsg.RudpMessage
 
@@ -118,6 +246,11 @@ func (r *ReliableUdp) decodePacket(b []byte, ip string, port int) {
 	var msgType rudpmsg.RudpMsgType = *msg.Type
 	fclog.DEBUG("PACKET TYPE=%d", int32(msgType))
 
+	if initial && msgType != rudpmsg.RudpMsgType_MSG_RUDP_REG && msgType != rudpmsg.RudpMsgType_MSG_RUDP_REG_RS {
+		fclog.ERROR("Drop packet sealed with initial key type=%d", int32(msgType))
+		return
+	}
+
 	switch {
 
 	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_DATA:
@@ -128,6 +261,18 @@ func (r *ReliableUdp) decodePacket(b []byte, ip string, port int) {
 		r.processMsgReg(msg.Data, ip, port)
 	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_REG_RS:
 		r.processMsgRegRs(msg.Data, ip, port)
+	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_CLOSE:
+		r.processMsgClose(msg.Data, ip, port)
+	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_CLOSE_RS:
+		r.processMsgCloseRs(msg.Data, ip, port)
+	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_PING:
+		r.processMsgPing(msg.Data, ip, port)
+	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_PONG:
+		r.processMsgPong(msg.Data, ip, port)
+	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_PROBE:
+		r.processMsgProbe(msg.Data, ip, port)
+	case msgType == rudpmsg.RudpMsgType_MSG_RUDP_PROBE_RS:
+		r.processMsgProbeRs(msg.Data, ip, port)
 	}
 
 }
@@ -150,23 +295,45 @@ func (r *ReliableUdp) processMsgData(b []byte, ip string, port int) {
 
 	r.lock.Lock()
 
+	// Data of unknown sessions gets no reset, for the same reason as closes.
 	udpSession, exist := r.sessionMap[sid]
 	if !exist {
+		r.unlock()
 		fclog.ERROR("Receive invalid data sid=%d seq=%d", sid, seq)
 		return
 	}
 
-	udpSession.SendAck(seq)
+	if udpSession.IsClosed() {
+		r.unlock()
+		fclog.DEBUG("Drop data of closed session sid=%d seq=%d", sid, seq)
+		return
+	}
+
+	r.checkPeerAddr(udpSession, ip, port)
+	udpSession.UpdateRecvTs()
 
 	fclog.DEBUG("Receice udp data: seq=%d data='%s'", seq, string(data))
 
-	insertOK := udpSession.OnDataRecv(seq, data)
+	var insertOK bool
+	mode := msgData.GetMode()
+	if msgData.GetStream() != STREAM_DEFAULT {
+		insertOK = udpSession.OnStreamRecv(seq, msgData.GetStream(), msgData.GetSsn(), data, msgData.GetFrag(), msgData.GetTotal(), msgData.GetFin())
+		udpSession.OnDataAck(seq, insertOK)
+	} else if mode == DELIVERY_UNRELIABLE || mode == DELIVERY_UNRELIABLE_SEQUENCED {
+		insertOK = udpSession.OnUnreliableRecv(seq, data, mode)
+	} else {
+		insertOK = udpSession.OnDataRecv(seq, data, msgData.GetFrag(), msgData.GetTotal(), mode)
+		udpSession.OnDataAck(seq, insertOK)
+	}
 
-	r.lock.Unlock()
+	r.unlock()
 
 	if insertOK {
 		fclog.DEBUG("signal---->")
-		r.readChan <- true
+		select {
+		case r.readChan <- true:
+		case <-r.closeChan:
+		}
 		fclog.DEBUG("signal OK---->")
 	}
 }
@@ -187,7 +354,7 @@ func (r *ReliableUdp) processMsgAck(b []byte, ip string, port int) {
 	seq := int64(*msgData.Seq)
 
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
 
 	udpSession, exist := r.sessionMap[sid]
 	if !exist {
@@ -195,7 +362,16 @@ func (r *ReliableUdp) processMsgAck(b []byte, ip string, port int) {
 		return
 	}
 
-	udpSession.OnAck(seq)
+	r.checkPeerAddr(udpSession, ip, port)
+	udpSession.UpdateRecvTs()
+
+	if udpSession.OnAck(&msgData) {
+		r.queueEvent(func(inter RudpInter) {
+			inter.OnSendDrained(sid)
+		})
+	}
+
+	r.sendCond.Broadcast()
 }
 
 func (r *ReliableUdp) processMsgReg(b []byte, ip string, port int) {
@@ -214,27 +390,65 @@ func (r *ReliableUdp) processMsgReg(b []byte, ip string, port int) {
 	seq := int64(*msgData.Seq)
 
 	r.lock.Lock()
-	_, exist := r.sessionMap[sid]
-	r.lock.Unlock()
+	defer r.unlock()
 
+	udpSession, exist := r.sessionMap[sid]
 	if exist {
+		if !udpSession.IsClosed() && udpSession.IsPeerAddr(ip, port) {
+			// The response or our ack got lost, answer with both again.
+			fclog.DEBUG("Duplicate register sid=%d", sid)
+			udpSession.SendAck(seq)
+			udpSession.ResendControl()
+			return
+		}
+
 		fclog.ERROR("Register error!, exist sessioin id! id=%d", sid)
-		r.sendInvalidSessionRs(sid, ip, port)
+		r.sendRegisterRsCode(sid, REG_RS_CODE_EXIST, ip, port)
 		return
 	}
 
-	var udpSession *UdpSession = new(UdpSession)
+	if r.listener != nil && r.listener.isFull() {
+		fclog.ERROR("Register rejected, accept backlog full sid=%d", sid)
+		r.sendRegisterRsCode(sid, REG_RS_CODE_BACKLOG_FULL, ip, port)
+		return
+	}
+
+	var msgRs rudpmsg.RudpMsgRegRs
+	key, ok := r.handshake.AcceptRegister(sid, &msgData, &msgRs)
+	if !ok {
+		fclog.ERROR("Register authentication failed sid=%d", sid)
+		r.sendRegisterRsCode(sid, REG_RS_CODE_AUTH_FAILED, ip, port)
+		return
+	}
+
+	r.addCodecSession(sid, false)
+
+	udpSession = new(UdpSession)
 	udpSession.Init(sid, ip, port, r.udpSocket, r)
+	if msgData.GetSeqbits() != SEQ_BITS_32 {
+		udpSession.SetSeqBits(SEQ_BITS_16)
+	}
+	udpSession.SetPeerRing(int(msgData.GetWnd()))
+	udpSession.SetEstablished()
 	r.sessionMap[sid] = udpSession
 
 	udpSession.SendAck(seq)
-	if !udpSession.SendRegisterRs() {
+	err = udpSession.SendRegisterRs(&msgRs)
+	if err != nil {
+		fclog.ERROR("SendRegisterRs error! err=%s", err.Error())
+	}
 
-		fclog.ERROR("SendRegisterRs error!")
+	if key != nil {
+		r.setCodecSessionKey(sid, key)
 	}
 
-	fclog.DEBUG("SendRegisterRs OK. ID=%d create", sid)
+	if r.listener != nil {
+		conn := newRudpConn(r, sid, udpSession.GetPeerAddr())
+		r.connMap[sid] = conn
+		r.listener.onAccept(conn)
+	}
 
+	fclog.DEBUG("SendRegisterRs OK. ID=%d create", sid)
 }
 
 func (r *ReliableUdp) processMsgRegRs(b []byte, ip string, port int) {
@@ -254,220 +468,1618 @@ func (r *ReliableUdp) processMsgRegRs(b []byte, ip string, port int) {
 	code := int64(*msgData.Code)
 
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
 
 	udpSession, exist := r.sessionMap[sid]
 	if !exist {
 		fclog.ERROR("Receive invalid data sid=%d seq=%d", sid, seq)
-		r.udpInter.OnSessionCreate(sid, UDP_SESSION_RS_ERR)
+		r.onSessionCreate(sid, UDP_SESSION_RS_ERR)
 		return
 	}
 
-	udpSession.SendAck(seq)
+	udpSession.UpdateRecvTs()
+
+	if udpSession.IsEstablished() {
+		udpSession.SendAck(seq)
+		return
+	}
 
 	fclog.DEBUG("Receive udp session response sessionid=%d code=%d", sid, code)
 
-	r.udpInter.OnSessionCreate(sid, UDP_SESSION_RS_OK)
+	if code != REG_RS_CODE_OK {
+		// Failure codes carry no MAC, with a PSK anyone could send one. A
+		// real rejection then ends at the registration deadline.
+		if r.handshake.IsAuthenticated() {
+			fclog.ERROR("Ignore unauthenticated register failure sid=%d code=%d", sid, code)
+			return
+		}
+		fclog.ERROR("Session register rejected sid=%d code=%d", sid, code)
+		r.dropSession(sid)
+		r.onSessionCreate(sid, UDP_SESSION_RS_ERR)
+		return
+	}
+
+	// A response failing authentication may be forged, keep waiting for the
+	// one of the peer.
+	key, ok := r.handshake.FinishRegister(sid, udpSession.GetHandshakeState(), &msgData)
+	if !ok {
+		fclog.ERROR("Ignore register response failing authentication sid=%d", sid)
+		return
+	}
+
+	if key != nil {
+		r.setCodecSessionKey(sid, key)
+	}
+
+	udpSession.SetSeqBits(int(msgData.GetSeqbits()))
+	udpSession.SetPeerRing(int(msgData.GetWnd()))
+	udpSession.SetEstablished()
+	udpSession.SendAck(seq)
+
+	// Data sent before the registration completed waits in the backlog or
+	// in sendData.
+	udpSession.FlushBacklog()
+	r.sendCond.Broadcast()
+
+	r.onSessionCreate(sid, UDP_SESSION_RS_OK)
 }
 
-func (r *ReliableUdp) CreateSession(ip string, port int) (int64, error) {
+func (r *ReliableUdp) processMsgClose(b []byte, ip string, port int) {
 
-	sid := time.Now().UnixNano()
+	fclog.DEBUG("processMsgClose")
 
-	var udpSession *UdpSession = new(UdpSession)
-	udpSession.Init(sid, ip, port, r.udpSocket, r)
+	var msgData rudpmsg.RudpMsgClose
+	err := proto.Unmarshal(b, &msgData)
+
+	if err != nil {
+		fclog.ERROR("Unmarshal error! err=%s", err.Error())
+		return
+	}
+
+	sid := int64(*msgData.Sid)
+	seq := int64(*msgData.Seq)
+	code := msgData.GetCode()
 
 	r.lock.Lock()
-	r.sessionMap[sid] = udpSession
-	r.lock.Unlock()
+	defer r.unlock()
 
-	err := udpSession.SendRegister(sid)
+	// Closes of unknown sessions get no answer, so forged ones can't make us
+	// send to a spoofed address. A peer whose answer was lost times out.
+	udpSession, exist := r.sessionMap[sid]
+	if !exist {
+		fclog.DEBUG("Receive close of unknown session sid=%d code=%d", sid, code)
+		return
+	}
 
-	return sid, err
+	// Only the peer address may close, a forged close from elsewhere would
+	// tear down a live session.
+	if !udpSession.IsPeerAddr(ip, port) {
+		fclog.ERROR("Drop close from foreign address sid=%d addr=%s:%d", sid, ip, port)
+		return
+	}
+
+	r.sendCloseRs(sid, seq, code, ip, port)
+
+	udpSession.OnPeerClose()
+	r.removeSession(sid, int(code))
+
+	fclog.DEBUG("Session closed by peer sid=%d code=%d", sid, code)
 }
 
-func (r *ReliableUdp) sendInvalidSessionRs(sid int64, ip string, port int) {
+func (r *ReliableUdp) processMsgCloseRs(b []byte, ip string, port int) {
 
-	var msg rudpmsg.RudpMsgRegRs
-	msg.Seq = proto.Int64(0)
-	msg.Sid = proto.Int64(sid)
-	msg.Code = proto.Int64(10001)
+	fclog.DEBUG("processMsgCloseRs")
+
+	var msgData rudpmsg.RudpMsgCloseRs
+	err := proto.Unmarshal(b, &msgData)
 
-	data, err := proto.Marshal(&msg)
 	if err != nil {
-		fclog.ERROR("Marshal message error!")
+		fclog.ERROR("Unmarshal error! err=%s", err.Error())
 		return
 	}
 
-	packetData := rudpmsg.EncodePacket(data, rudpmsg.RudpMsgType_MSG_RUDP_REG_RS)
+	sid := int64(*msgData.Sid)
 
-	if len(packetData) <= 0 {
-		fclog.ERROR("EncodePacket error!")
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, exist := r.sessionMap[sid]
+	if !exist || !udpSession.IsClosed() || !udpSession.IsPeerAddr(ip, port) {
+		fclog.DEBUG("Receive close response of unknown session sid=%d", sid)
 		return
 	}
 
-	encryptData := r.encrypt.EncodePacket(packetData)
-	dstAddr := &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
-	r.udpSocket.SendData(encryptData, dstAddr)
+	r.removeSession(sid, udpSession.GetCloseCode())
+
+	fclog.DEBUG("Session close confirmed sid=%d", sid)
 }
 
-func (r *ReliableUdp) SetMaxRetransmissionCount(sessionId int64, count int) {
+func (r *ReliableUdp) processMsgPing(b []byte, ip string, port int) {
+
+	var msgData rudpmsg.RudpMsgPing
+	err := proto.Unmarshal(b, &msgData)
+
+	if err != nil {
+		fclog.ERROR("Unmarshal error! err=%s", err.Error())
+		return
+	}
+
+	sid := int64(*msgData.Sid)
 
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
 
-	udpSession, exist := r.sessionMap[sessionId]
-	if !exist {
-		fclog.ERROR("SetMaxRetransmissionCount error! sid=%d count=%d", sessionId, count)
+	udpSession, exist := r.sessionMap[sid]
+	if !exist || udpSession.IsClosed() {
+		fclog.ERROR("Receive ping of invalid session sid=%d", sid)
 		return
 	}
 
-	udpSession.SetMaxRetransmissionCount(count)
+	r.checkPeerAddr(udpSession, ip, port)
+	udpSession.UpdateRecvTs()
+	udpSession.SendPong(msgData.GetSeq(), msgData.GetTs())
 }
 
-func (r *ReliableUdp) SetRetransmissionInterval(sessionId int64, usecond int) {
+func (r *ReliableUdp) processMsgPong(b []byte, ip string, port int) {
+
+	var msgData rudpmsg.RudpMsgPong
+	err := proto.Unmarshal(b, &msgData)
+
+	if err != nil {
+		fclog.ERROR("Unmarshal error! err=%s", err.Error())
+		return
+	}
+
+	sid := int64(*msgData.Sid)
 
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
 
-	udpSession, exist := r.sessionMap[sessionId]
+	udpSession, exist := r.sessionMap[sid]
 	if !exist {
-		fclog.ERROR("SetRetransmissionInterval error! sid=%d count=%d", sessionId, usecond)
+		fclog.ERROR("Receive pong of invalid session sid=%d", sid)
+		return
+	}
+
+	udpSession.UpdateRecvTs()
+
+	if !udpSession.IsPeerAddr(ip, port) {
+		if udpSession.OnPathResponse(ip, port, msgData.GetTs()) {
+			fclog.INFO("Peer address changed sid=%d addr=%s:%d", sid, ip, port)
+			r.onPeerAddrChange(sid, ip, port)
+		}
 		return
 	}
 
-	udpSession.SetRetransmissionInterval(usecond)
+	fclog.DEBUG("Receive pong sid=%d rtt=%d", sid, time.Now().UnixNano()-msgData.GetTs())
 }
 
-func (r *ReliableUdp) sessionRetransmissionCheck() {
+func (r *ReliableUdp) processMsgProbe(b []byte, ip string, port int) {
 
-	for {
-		time.Sleep(1000000 * 1000)
-		r.lock.Lock()
-		for _, session := range r.sessionMap {
-			session.RetransmissionCheck()
-		}
-		r.lock.Unlock()
+	var msgData rudpmsg.RudpMsgProbe
+	err := proto.Unmarshal(b, &msgData)
+
+	if err != nil {
+		fclog.ERROR("Unmarshal error! err=%s", err.Error())
+		return
+	}
+
+	sid := int64(*msgData.Sid)
+
+	r.lock.Lock()
+	defer r.unlock()
 
+	udpSession, exist := r.sessionMap[sid]
+	if !exist || udpSession.IsClosed() {
+		fclog.ERROR("Receive probe of invalid session sid=%d", sid)
+		return
 	}
+
+	r.checkPeerAddr(udpSession, ip, port)
+	udpSession.UpdateRecvTs()
+	udpSession.SendProbeRs(msgData.GetSeq(), msgData.GetSize())
 }
 
-func (r *ReliableUdp) sessionReadCheck() {
+func (r *ReliableUdp) processMsgProbeRs(b []byte, ip string, port int) {
 
-	for {
-		<-r.readChan
-		r.lock.Lock()
-		for sid, session := range r.sessionMap {
+	var msgData rudpmsg.RudpMsgProbeRs
+	err := proto.Unmarshal(b, &msgData)
 
-			for {
-				data, bHave := session.ReadCheck()
-				if bHave {
-					fclog.DEBUG("Find sequence packet")
-					r.udpInter.OnRecv(sid, data)
-				} else {
-					fclog.DEBUG("Break CHECK")
-					break
-				}
-			}
-		}
-		r.lock.Unlock()
-		fclog.DEBUG("Event fire check")
+	if err != nil {
+		fclog.ERROR("Unmarshal error! err=%s", err.Error())
+		return
+	}
+
+	sid := int64(*msgData.Sid)
 
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, exist := r.sessionMap[sid]
+	if !exist || udpSession.IsClosed() {
+		fclog.ERROR("Receive probe response of invalid session sid=%d", sid)
+		return
 	}
-}
 
-func (r *ReliableUdp) sessionReadTimeoutCheck() {
+	udpSession.UpdateRecvTs()
+	udpSession.OnProbeAck(msgData.GetSeq(), int(msgData.GetSize()))
+}
 
-	for {
-		select {
+func (r *ReliableUdp) removeSession(sid int64, code int) {
 
-		case <-time.After(time.Duration(r.readTimeOut) * time.Second):
-			r.lock.Lock()
-			for sid, session := range r.sessionMap {
+	r.dropSession(sid)
 
-				for {
-					data, bHave := session.ReadTimeoutCheck()
-					if bHave {
-						fclog.DEBUG("find time out packet")
-						r.udpInter.OnRecv(sid, data)
-					} else {
-						break
-					}
-				}
-			}
-			r.lock.Unlock()
-			fclog.DEBUG("time out check")
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnSessionClose(sid, code)
+	})
+}
 
-		}
+func (r *ReliableUdp) dropSession(sid int64) {
+	udpSession, exist := r.sessionMap[sid]
+	if exist {
+		udpSession.CancelTimers()
+	}
+	delete(r.sessionMap, sid)
+	conn, exist := r.connMap[sid]
+	if exist {
+		delete(r.connMap, sid)
+		conn.onClose()
+	}
+	r.sendCond.Broadcast()
+	sessionCodec, ok := r.getCodec().(SessionCodec)
+	if ok {
+		sessionCodec.RemoveSession(sid)
 	}
 }
 
-func (r *ReliableUdp) SendData(sessionId int64, b []byte) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+// lookupSession returns the session of sessionId, the caller holds the lock.
+func (r *ReliableUdp) lookupSession(sessionId int64) (*UdpSession, error) {
 
 	udpSession, exist := r.sessionMap[sessionId]
 	if !exist {
-		fclog.ERROR("SendData error! sid=%d", sessionId)
-		return
+		return nil, ErrUnknownSession
 	}
 
-	udpSession.SendData(b)
-}
+	if udpSession.IsClosed() {
+		return nil, ErrSessionClosed
+	}
 
-func (r *ReliableUdp) GetEncrypt() *RudpEncrypt {
-	return &r.encrypt
+	return udpSession, nil
 }
 
-func (r *ReliableUdp) Stat(addr string) {
-	go r.StatCalc()
-	go r.StatRun(addr)
+func (r *ReliableUdp) checkPeerAddr(udpSession *UdpSession, ip string, port int) {
+
+	if udpSession.IsPeerAddr(ip, port) {
+		return
+	}
+
+	fclog.INFO("Validate new peer address sid=%d addr=%s:%d", udpSession.GetSid(), ip, port)
+
+	udpSession.ValidatePath(ip, port, time.Now().UnixNano())
 }
 
-func (r *ReliableUdp) StatRun(addr string) {
-	http.HandleFunc("/stat", r.StatFunc)
-	http.ListenAndServe(addr, nil)
+func (r *ReliableUdp) CreateSession(ip string, port int) (int64, error) {
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	return r.createSession(ip, port)
 }
 
-func (r *ReliableUdp) StatCalc() {
+func (r *ReliableUdp) createSession(ip string, port int) (int64, error) {
 
-	for {
+	sid := time.Now().UnixNano()
+
+	r.addCodecSession(sid, true)
 
-		time.Sleep(1000000 * 5000)
+	var udpSession *UdpSession = new(UdpSession)
+	udpSession.Init(sid, ip, port, r.udpSocket, r)
 
-		if len(r.sessionMap) > 0 {
+	r.sessionMap[sid] = udpSession
+	udpSession.SetRegisterDeadline(time.Now().UnixNano() + r.dialTimeout)
 
-			r.lock.Lock()
+	err := udpSession.SendRegister(sid)
 
-			var statInfo StatInfo
-			statInfo.Count = len(r.sessionMap)
-			statInfo.Sessions = make([]StatItem, 0)
+	return sid, err
+}
 
-			for k, session := range r.sessionMap {
-				var item StatItem
-				item.SessionId = int(k)
-				item.Lossrate = session.GetLossrate()
-				item.Retransmissionrate = session.GetRetransmissionrate()
-				statInfo.Sessions = append(statInfo.Sessions, item)
-			}
+// Dial creates a session to ip:port and returns it as a net.Conn once the
+// peer accepted the registration. Data of the session is then read from the
+// connection instead of being passed to RudpInter.OnRecv.
+func (r *ReliableUdp) Dial(ip string, port int) (*RudpConn, error) {
 
-			data, err := json.Marshal(statInfo)
-			if err == nil {
-				r.statData = data
-			} else {
-				fclog.ERROR("Marshal json err! err=%s", err.Error())
-			}
+	r.lock.Lock()
+	sid, err := r.createSession(ip, port)
+	if err != nil {
+		r.unlock()
+		return nil, err
+	}
 
-			r.lock.Unlock()
+	conn := newRudpConn(r, sid, net.UDPAddr{IP: net.ParseIP(ip), Port: port})
+	r.connMap[sid] = conn
+	r.unlock()
 
-		} else {
-			statData := `{"count":0, "sessions":[]}`
-			r.statData = []byte(statData)
+	select {
+	case code := <-conn.createChan:
+		if code == UDP_SESSION_RS_OK {
+			return conn, nil
 		}
-
+		if conn.isTimeout() {
+			return nil, os.ErrDeadlineExceeded
+		}
+		return nil, ErrSessionCreateFailed
+	case <-r.closeChan:
+		return nil, net.ErrClosed
 	}
 }
 
-func (r *ReliableUdp) StatFunc(w http.ResponseWriter, req *http.Request) {
-	w.Write(r.statData)
+// NewConn returns the session as a net.Conn. Data received before the call
+// has already been passed to RudpInter.OnRecv.
+func (r *ReliableUdp) NewConn(sessionId int64) (*RudpConn, error) {
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("NewConn error! sid=%d", sessionId)
+		return nil, err
+	}
+	if udpSession.IsClosing() {
+		fclog.ERROR("NewConn error! session is closing sid=%d", sessionId)
+		return nil, ErrSessionClosed
+	}
+
+	conn, exist := r.connMap[sessionId]
+	if exist {
+		return conn, nil
+	}
+
+	conn = newRudpConn(r, sessionId, udpSession.GetPeerAddr())
+	r.connMap[sessionId] = conn
+
+	return conn, nil
+}
+
+// SetDialTimeout sets how long sessions created by CreateSession or Dial
+// wait for the registration response. Sessions without one by then are
+// dropped and reported to OnSessionCreate with UDP_SESSION_RS_ERR.
+func (r *ReliableUdp) SetDialTimeout(msecond int) error {
+	if msecond <= 0 {
+		fclog.ERROR("SetDialTimeout error! timeout=%d", msecond)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dialTimeout = int64(msecond) * 1000000
+	return nil
+}
+
+func (r *ReliableUdp) onSessionCreate(sid int64, code int) {
+	conn, exist := r.connMap[sid]
+	if exist {
+		conn.onCreate(code)
+	}
+
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnSessionCreate(sid, code)
+	})
+}
+
+func (r *ReliableUdp) onMessage(sid int64, data []byte) {
+	conn, exist := r.connMap[sid]
+	if exist {
+		conn.onRecv(data)
+		return
+	}
+
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnMessage(sid, data)
+	})
+}
+
+func (r *ReliableUdp) onRecv(sid int64, data []byte) {
+	conn, exist := r.connMap[sid]
+	if exist {
+		conn.onRecv(data)
+		return
+	}
+
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnRecv(sid, data)
+	})
+}
+
+func (r *ReliableUdp) onStreamMessage(sid int64, streamId int32, data []byte) {
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnStreamMessage(sid, streamId, data)
+	})
+}
+
+func (r *ReliableUdp) onStreamClose(sid int64, streamId int32) {
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnStreamClose(sid, streamId)
+	})
+}
+
+func (r *ReliableUdp) onSessionError(sid int64, code int) {
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnSessionError(sid, code)
+	})
+}
+
+func (r *ReliableUdp) onPeerAddrChange(sid int64, ip string, port int) {
+	r.queueEvent(func(inter RudpInter) {
+		inter.OnPeerAddrChange(sid, ip, port)
+	})
+}
+
+// queueEvent defers a RudpInter callback until the endpoint lock is
+// released, the caller holds the lock.
+func (r *ReliableUdp) queueEvent(event func(inter RudpInter)) {
+	r.events = append(r.events, event)
+}
+
+// unlock releases the endpoint lock, then runs the callbacks queued while it
+// was held, in order. Callbacks may call back into the endpoint.
+func (r *ReliableUdp) unlock() {
+	events := r.events
+	r.events = nil
+	inter := r.udpInter
+	r.lock.Unlock()
+
+	for _, event := range events {
+		event(inter)
+	}
+}
+
+func (r *ReliableUdp) sendRegisterRsCode(sid int64, code int, ip string, port int) {
+
+	var msg rudpmsg.RudpMsgRegRs
+	msg.Seq = proto.Int64(SEQ_CONTROL)
+	msg.Sid = proto.Int64(sid)
+	msg.Code = proto.Int64(int64(code))
+
+	r.sendMsg(sid, &msg, rudpmsg.RudpMsgType_MSG_RUDP_REG_RS, ip, port)
+}
+
+func (r *ReliableUdp) sendCloseRs(sid int64, seq int64, code int64, ip string, port int) {
+
+	var msg rudpmsg.RudpMsgCloseRs
+	msg.Seq = proto.Int64(seq)
+	msg.Sid = proto.Int64(sid)
+	msg.Code = proto.Int64(code)
+
+	r.sendMsg(sid, &msg, rudpmsg.RudpMsgType_MSG_RUDP_CLOSE_RS, ip, port)
+}
+
+func (r *ReliableUdp) sendMsg(sid int64, msg proto.Message, msgType rudpmsg.RudpMsgType, ip string, port int) {
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		fclog.ERROR("Marshal message error!")
+		return
+	}
+
+	packetData := rudpmsg.EncodePacket(data, msgType)
+
+	if len(packetData) <= 0 {
+		fclog.ERROR("EncodePacket error!")
+		return
+	}
+
+	encryptData := r.encodePacket(sid, packetData)
+	if encryptData == nil {
+		fclog.ERROR("Encode packet error! sid=%d", sid)
+		return
+	}
+
+	dstAddr := &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+	r.udpSocket.SendData(encryptData, dstAddr)
+}
+
+// SetMaxRetransmissionCount bounds the retransmissions of a packet before
+// the session is closed, 0 disables retransmission and -1 removes the bound.
+func (r *ReliableUdp) SetMaxRetransmissionCount(sessionId int64, count int) error {
+
+	if count < -1 {
+		fclog.ERROR("SetMaxRetransmissionCount error! sid=%d count=%d", sessionId, count)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetMaxRetransmissionCount error! sid=%d count=%d", sessionId, count)
+		return err
+	}
+
+	udpSession.SetMaxRetransmissionCount(count)
+
+	return nil
+}
+
+func (r *ReliableUdp) SetRetransmissionInterval(sessionId int64, msecond int) error {
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetRetransmissionInterval error! sid=%d interval=%d", sessionId, msecond)
+		return err
+	}
+
+	udpSession.SetRetransmissionInterval(msecond)
+
+	return nil
+}
+
+func (r *ReliableUdp) SetFastRetransThreshold(sessionId int64, threshold int) error {
+
+	if threshold < 0 {
+		fclog.ERROR("SetFastRetransThreshold error! sid=%d threshold=%d", sessionId, threshold)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetFastRetransThreshold error! sid=%d threshold=%d", sessionId, threshold)
+		return err
+	}
+
+	udpSession.SetFastRetransThreshold(threshold)
+
+	return nil
+}
+
+func (r *ReliableUdp) SetRtoBounds(sessionId int64, minMsecond int, maxMsecond int) error {
+
+	if minMsecond <= 0 || minMsecond > maxMsecond {
+		fclog.ERROR("SetRtoBounds error! sid=%d min=%d max=%d", sessionId, minMsecond, maxMsecond)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetRtoBounds error! sid=%d", sessionId)
+		return err
+	}
+
+	return udpSession.SetRtoBounds(minMsecond, maxMsecond)
+}
+
+func (r *ReliableUdp) sessionRetransmissionCheck() {
+
+	defer r.wg.Done()
+
+	timer := time.NewTimer(TIMER_IDLE_WAIT)
+	defer timer.Stop()
+
+	for {
+		r.lock.Lock()
+		curTs := time.Now().UnixNano()
+		expired := r.timer.Expire(curTs)
+		for _, entry := range expired {
+			r.onTimerExpire(entry, curTs)
+		}
+		if len(expired) > 0 {
+			r.sendCond.Broadcast()
+		}
+		delay := r.timer.NextDelay(time.Now().UnixNano())
+		r.unlock()
+
+		timer.Reset(delay)
+
+		select {
+		case <-timer.C:
+		case <-r.timer.wakeChan:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+func (r *ReliableUdp) onTimerExpire(entry *timerEntry, curTs int64) {
+
+	session := entry.session
+	sid := session.GetSid()
+
+	if r.sessionMap[sid] != session {
+		return
+	}
+
+	if entry.item == nil {
+		if session.IsCloseExpired(curTs) {
+			fclog.INFO("Close response timeout sid=%d", sid)
+			r.removeSession(sid, session.GetCloseCode())
+		}
+		if session.IsRegisterExpired(curTs) {
+			fclog.INFO("Register response timeout sid=%d", sid)
+			conn, exist := r.connMap[sid]
+			if exist {
+				conn.onDialTimeout()
+			}
+			r.dropSession(sid)
+			r.onSessionCreate(sid, UDP_SESSION_RS_ERR)
+		}
+		return
+	}
+
+	session.OnRetransTimer(entry.seq, entry.item, entry.deadline, curTs)
+	r.sendCond.Broadcast()
+}
+
+// SetDefaultKeepalive turns on keepalive for sessions created later: a PING
+// after intervalMsecond without traffic, and eviction after timeoutMsecond
+// without any packet from the peer. It is off by default, peers that don't
+// answer PING would be evicted while idle. 0 disables either.
+func (r *ReliableUdp) SetDefaultKeepalive(intervalMsecond int, timeoutMsecond int) error {
+	if intervalMsecond < 0 || timeoutMsecond < 0 {
+		fclog.ERROR("SetDefaultKeepalive error! interval=%d timeout=%d", intervalMsecond, timeoutMsecond)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.pingInterval = int64(intervalMsecond) * 1000000
+	r.idleTimeout = int64(timeoutMsecond) * 1000000
+	return nil
+}
+
+func (r *ReliableUdp) SetKeepalive(sessionId int64, intervalMsecond int, timeoutMsecond int) error {
+
+	if intervalMsecond < 0 || timeoutMsecond < 0 {
+		fclog.ERROR("SetKeepalive error! sid=%d interval=%d timeout=%d", sessionId, intervalMsecond, timeoutMsecond)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetKeepalive error! sid=%d interval=%d timeout=%d", sessionId, intervalMsecond, timeoutMsecond)
+		return err
+	}
+
+	udpSession.SetKeepalive(intervalMsecond, timeoutMsecond)
+
+	return nil
+}
+
+func (r *ReliableUdp) sessionKeepaliveCheck() {
+
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-time.After(1000000 * 100):
+		case <-r.closeChan:
+			return
+		}
+
+		r.lock.Lock()
+		curTs := time.Now().UnixNano()
+		for sid, session := range r.sessionMap {
+			if session.IsClosed() {
+				continue
+			}
+
+			if session.IsIdleTimeout(curTs) {
+				fclog.ERROR("Session peer timeout, evict sid=%d", sid)
+				session.OnPeerClose()
+				r.onSessionError(sid, UDP_SESSION_ERR_PEER_TIMEOUT)
+				r.removeSession(sid, CLOSE_REASON_TIMEOUT)
+				continue
+			}
+
+			session.KeepaliveCheck(curTs)
+
+			if session.IsEstablished() && !session.IsClosing() {
+				session.PmtuCheck(curTs)
+			}
+		}
+		r.unlock()
+	}
+}
+
+// SetAckDelay sets how long an ack may wait for more packets to cover,
+// from 1 millisecond to ACK_DELAY_MAX.
+func (r *ReliableUdp) SetAckDelay(msecond int) error {
+	if msecond < 1 || int64(msecond)*1000000 > ACK_DELAY_MAX {
+		fclog.ERROR("SetAckDelay error! delay=%d", msecond)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.ackDelay = int64(msecond) * 1000000
+	return nil
+}
+
+func (r *ReliableUdp) sessionAckCheck() {
+
+	defer r.wg.Done()
+
+	for {
+		r.lock.Lock()
+		ackDelay := r.ackDelay
+		r.unlock()
+
+		select {
+		case <-time.After(time.Duration(ackDelay)):
+		case <-r.closeChan:
+			return
+		}
+
+		r.lock.Lock()
+		curTs := time.Now().UnixNano()
+		for _, session := range r.sessionMap {
+			session.AckCheck(curTs)
+		}
+		r.unlock()
+	}
+}
+
+func (r *ReliableUdp) sessionReadCheck() {
+
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.readChan:
+		case <-r.closeChan:
+			return
+		}
+
+		r.lock.Lock()
+		for sid, session := range r.sessionMap {
+
+			conn, isConn := r.connMap[sid]
+			wnd := session.GetRecvWindow()
+
+			for {
+				if isConn && conn.isFull() {
+					break
+				}
+
+				data, message, bHave := session.ReadCheck()
+				if bHave {
+					fclog.DEBUG("Find sequence packet")
+					if message {
+						r.onMessage(sid, data)
+					} else {
+						r.onRecv(sid, data)
+					}
+				} else {
+					fclog.DEBUG("Break CHECK")
+					break
+				}
+			}
+
+			// Tell the peer at once when a full conn makes room again.
+			if wnd == 0 && session.GetRecvWindow() > 0 {
+				session.SendSack()
+			}
+
+			for {
+				streamId, data, fin, bHave := session.ReadStreamCheck()
+				if !bHave {
+					break
+				}
+				if fin {
+					r.onStreamClose(sid, streamId)
+				} else {
+					r.onStreamMessage(sid, streamId, data)
+				}
+			}
+		}
+		r.unlock()
+		fclog.DEBUG("Event fire check")
+
+	}
+}
+
+// wakeRead makes the read goroutine deliver the data it held back for a full
+// conn.
+func (r *ReliableUdp) wakeRead() {
+	select {
+	case r.readChan <- true:
+	case <-r.closeChan:
+	}
+}
+
+// SendData sends b on the session. When the send window is full it fails
+// with ErrWindowFull, waits for the window to open or queues the data,
+// according to the window policy of the session.
+func (r *ReliableUdp) SendData(sessionId int64, b []byte) error {
+	return r.sendData(sessionId, STREAM_DEFAULT, b, false, DELIVERY_DEFAULT, false, time.Time{})
+}
+
+// SendDataMode sends b with the given delivery mode instead of the one of
+// the session. Unreliable data is sent at once, outside the send window.
+func (r *ReliableUdp) SendDataMode(sessionId int64, b []byte, mode int) error {
+	return r.sendData(sessionId, STREAM_DEFAULT, b, false, mode, false, time.Time{})
+}
+
+// SendMessage sends b as one message, fragmented to fit in packets, which
+// the peer passes whole to RudpInter.OnMessage. The window policy applies to
+// the first fragment, once it is accepted the others are queued as needed.
+// Messages with more fragments than the receive window of the peer fail with
+// ErrMessageTooLarge.
+func (r *ReliableUdp) SendMessage(sessionId int64, b []byte) error {
+	return r.sendData(sessionId, STREAM_DEFAULT, b, true, DELIVERY_RELIABLE_ORDERED, false, time.Time{})
+}
+
+// SendStream sends b as one message on an open stream. The peer passes it
+// to RudpInter.OnStreamMessage in the order of the stream, independently of
+// the other streams.
+func (r *ReliableUdp) SendStream(sessionId int64, streamId int32, b []byte) error {
+	if streamId == STREAM_DEFAULT {
+		return ErrUnknownStream
+	}
+	return r.sendData(sessionId, streamId, b, true, DELIVERY_RELIABLE_ORDERED, false, time.Time{})
+}
+
+// OpenStream opens a stream of the session, or changes its priority when it
+// is already open. Streams with a higher priority send first when the
+// window is limited, the session data counts as STREAM_PRIORITY_DEFAULT.
+// The peer opens a stream when its first data arrives.
+func (r *ReliableUdp) OpenStream(sessionId int64, streamId int32, priority int) error {
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("OpenStream error! sid=%d stream=%d", sessionId, streamId)
+		return err
+	}
+
+	return udpSession.OpenStream(streamId, priority)
+}
+
+// CloseStream closes a stream once its queued data is sent. The peer gets
+// RudpInter.OnStreamClose after the last message and closes its side too,
+// which in turn fires OnStreamClose here.
+func (r *ReliableUdp) CloseStream(sessionId int64, streamId int32) error {
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("CloseStream error! sid=%d stream=%d", sessionId, streamId)
+		return err
+	}
+
+	return udpSession.CloseStream(streamId)
+}
+
+// SetMaxStreams bounds the streams open at once in sessions created later.
+// Data of further streams opened by the peer is dropped.
+func (r *ReliableUdp) SetMaxStreams(count int) error {
+	if count < 0 {
+		fclog.ERROR("SetMaxStreams error! count=%d", count)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.maxStreams = count
+	return nil
+}
+
+// sendData sends b, waiting for the window regardless of the window policy
+// when block is set. A non-zero deadline bounds the wait.
+func (r *ReliableUdp) sendData(sessionId int64, streamId int32, b []byte, message bool, mode int, block bool, deadline time.Time) error {
+	r.lock.Lock()
+	defer r.unlock()
+
+	if mode != DELIVERY_DEFAULT && !isDeliveryMode(mode) {
+		fclog.ERROR("SendData error! sid=%d mode=%d", sessionId, mode)
+		return ErrInvalidArgument
+	}
+
+	if message && len(b) > r.maxMessageSize {
+		fclog.ERROR("SendMessage error! message too large sid=%d len=%d", sessionId, len(b))
+		return ErrMessageTooLarge
+	}
+
+	var timer *time.Timer = nil
+
+	for {
+		udpSession, err := r.lookupSession(sessionId)
+		if err != nil {
+			fclog.ERROR("SendData error! sid=%d", sessionId)
+			return err
+		}
+
+		if udpSession.IsClosing() {
+			fclog.ERROR("SendData error! session is closing sid=%d", sessionId)
+			return ErrSessionClosed
+		}
+
+		var stream *Stream = nil
+		if streamId != STREAM_DEFAULT {
+			stream = udpSession.GetStream(streamId)
+			if stream == nil {
+				fclog.ERROR("SendData error! unknown stream sid=%d stream=%d", sessionId, streamId)
+				return ErrUnknownStream
+			}
+			if stream.closing {
+				fclog.ERROR("SendData error! stream is closing sid=%d stream=%d", sessionId, streamId)
+				return ErrStreamClosed
+			}
+		}
+
+		fragSize := 0
+		count := 1
+		if message {
+			fragSize = udpSession.GetFragmentSize()
+			count = fragmentCount(len(b), fragSize)
+			// The peer drops messages with more fragments than its ring holds.
+			if count > udpSession.GetPeerRing() {
+				fclog.ERROR("SendMessage error! too many fragments sid=%d count=%d", sessionId, count)
+				return ErrMessageTooLarge
+			}
+		} else if len(b) > udpSession.GetMaxPayload() {
+			fclog.ERROR("SendData error! packet too large sid=%d len=%d", sessionId, len(b))
+			return ErrMessageTooLarge
+		}
+
+		if mode == DELIVERY_DEFAULT {
+			mode = udpSession.GetDeliveryMode()
+		}
+
+		if mode == DELIVERY_UNRELIABLE || mode == DELIVERY_UNRELIABLE_SEQUENCED {
+			return udpSession.SendUnreliable(b, int32(mode))
+		}
+
+		if udpSession.CanSend() {
+			return udpSession.Send(b, fragSize, mode, stream)
+		}
+
+		policy := udpSession.GetWindowPolicy()
+		if block {
+			policy = WINDOW_POLICY_BLOCK
+		}
+
+		switch policy {
+		case WINDOW_POLICY_BLOCK:
+			if !deadline.IsZero() {
+				if !time.Now().Before(deadline) {
+					return os.ErrDeadlineExceeded
+				}
+				if timer == nil {
+					timer = time.AfterFunc(time.Until(deadline), func() {
+						r.lock.Lock()
+						r.sendCond.Broadcast()
+						r.unlock()
+					})
+					defer timer.Stop()
+				}
+			}
+			r.sendCond.Wait()
+		case WINDOW_POLICY_QUEUE:
+			if udpSession.CanQueue(count) {
+				return udpSession.Send(b, fragSize, mode, stream)
+			}
+			return ErrWindowFull
+		default:
+			if !udpSession.IsEstablished() {
+				return ErrNotEstablished
+			}
+			return ErrWindowFull
+		}
+	}
+}
+
+// SetMaxMessageSize sets the largest message SendMessage sends and the
+// largest one reassembled from a peer.
+func (r *ReliableUdp) SetMaxMessageSize(size int) error {
+	if size <= 0 {
+		fclog.ERROR("SetMaxMessageSize error! size=%d", size)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.maxMessageSize = size
+	return nil
+}
+
+// GetFragmentSize returns the payload of one fragment of the session. It
+// follows the discovered path MTU.
+func (r *ReliableUdp) GetFragmentSize(sessionId int64) (int, error) {
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		return 0, err
+	}
+
+	return udpSession.GetFragmentSize(), nil
+}
+
+// SetMaxDatagramSize sets the largest datagram sent and received, the upper
+// bound of path MTU discovery. It must leave room for payload after
+// DATAGRAM_OVERHEAD. Set it before Listen or DialUDP, both peers should use
+// the same value.
+func (r *ReliableUdp) SetMaxDatagramSize(size int) error {
+	if size <= DATAGRAM_OVERHEAD {
+		fclog.ERROR("SetMaxDatagramSize error! size=%d", size)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.maxDatagramSize = size
+	if r.udpSocket != nil {
+		r.udpSocket.SetRecvBufferSize(size)
+	}
+	return nil
+}
+
+// SetPmtuDiscovery turns path MTU probing of new sessions on or off. When
+// off sessions keep to DATAGRAM_SIZE_BASE.
+func (r *ReliableUdp) SetPmtuDiscovery(enable bool) error {
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.pmtuDiscovery = enable
+	return nil
+}
+
+// SetWindowPolicy sets what SendData does when the send window of the
+// session is full. backlog bounds the queue of WINDOW_POLICY_QUEUE.
+func (r *ReliableUdp) SetWindowPolicy(sessionId int64, policy int, backlog int) error {
+
+	if !isWindowPolicy(policy) || backlog < 0 {
+		fclog.ERROR("SetWindowPolicy error! sid=%d policy=%d backlog=%d", sessionId, policy, backlog)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetWindowPolicy error! sid=%d policy=%d", sessionId, policy)
+		return err
+	}
+
+	udpSession.SetWindowPolicy(policy, backlog)
+	r.sendCond.Broadcast()
+
+	return nil
+}
+
+// SetDeliveryMode sets the delivery mode of the data the session sends with
+// SendData. Messages are always reliable and ordered.
+func (r *ReliableUdp) SetDeliveryMode(sessionId int64, mode int) error {
+
+	if !isDeliveryMode(mode) {
+		fclog.ERROR("SetDeliveryMode error! sid=%d mode=%d", sessionId, mode)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetDeliveryMode error! sid=%d mode=%d", sessionId, mode)
+		return err
+	}
+
+	udpSession.SetDeliveryMode(mode)
+
+	return nil
+}
+
+// SetDefaultDeliveryMode sets the delivery mode of sessions created later.
+func (r *ReliableUdp) SetDefaultDeliveryMode(mode int) error {
+	if !isDeliveryMode(mode) {
+		fclog.ERROR("SetDefaultDeliveryMode error! mode=%d", mode)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.deliveryMode = mode
+
+	return nil
+}
+
+// SetDefaultWindowPolicy sets the window policy of sessions created later.
+func (r *ReliableUdp) SetDefaultWindowPolicy(policy int) error {
+	if !isWindowPolicy(policy) {
+		fclog.ERROR("SetDefaultWindowPolicy error! policy=%d", policy)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.windowPolicy = policy
+
+	return nil
+}
+
+// SetPacingRate sets the pacing bitrate of the session, PACING_RATE_AUTO to
+// derive it from the congestion controller or PACING_RATE_OFF to disable it.
+func (r *ReliableUdp) SetPacingRate(sessionId int64, bitrate int64) error {
+
+	if bitrate < PACING_RATE_OFF {
+		fclog.ERROR("SetPacingRate error! sid=%d bitrate=%d", sessionId, bitrate)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetPacingRate error! sid=%d bitrate=%d", sessionId, bitrate)
+		return err
+	}
+
+	udpSession.SetPacingRate(bitrate)
+
+	return nil
+}
+
+// SetDefaultCongestionControl selects the congestion control algorithm of
+// sessions created later.
+func (r *ReliableUdp) SetDefaultCongestionControl(algo int) error {
+	if !isCongestionAlgo(algo) {
+		fclog.ERROR("SetDefaultCongestionControl error! algo=%d", algo)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.congestionAlgo = algo
+
+	return nil
+}
+
+func (r *ReliableUdp) SetCongestionControl(sessionId int64, algo int) error {
+	if !isCongestionAlgo(algo) {
+		fclog.ERROR("SetCongestionControl error! sid=%d algo=%d", sessionId, algo)
+		return ErrInvalidArgument
+	}
+
+	return r.SetCongestionController(sessionId, NewCongestionController(algo))
+}
+
+// SetCongestionController installs a congestion controller on the session,
+// one of the builtin algorithms or a custom implementation.
+func (r *ReliableUdp) SetCongestionController(sessionId int64, congestion CongestionController) error {
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		fclog.ERROR("SetCongestionController error! sid=%d", sessionId)
+		return err
+	}
+
+	udpSession.SetCongestionController(congestion)
+	r.sendCond.Broadcast()
+
+	return nil
+}
+
+// SetRecvWindow sets how many packets the receive buffer of sessions
+// created later may hold, advertised to peers in acks. It is also the
+// largest span of out of order packets accepted.
+func (r *ReliableUdp) SetRecvWindow(packets int) error {
+	if packets <= 0 {
+		fclog.ERROR("SetRecvWindow error! packets=%d", packets)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	if packets > SEND_WINDOW_MAX {
+		packets = SEND_WINDOW_MAX
+	}
+	r.recvWindow = packets
+	return nil
+}
+
+func (r *ReliableUdp) SetCloseTimeout(msecond int) error {
+	if msecond <= 0 {
+		fclog.ERROR("SetCloseTimeout error! timeout=%d", msecond)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.closeTimeout = int64(msecond) * 1000000
+	return nil
+}
+
+func (r *ReliableUdp) CloseSession(sessionId int64, policy int) error {
+
+	r.lock.Lock()
+	udpSession, err := r.lookupSession(sessionId)
+	if err != nil {
+		r.unlock()
+		fclog.ERROR("CloseSession error! sid=%d", sessionId)
+		return err
+	}
+	udpSession.SetClosing()
+	r.sendCond.Broadcast()
+	r.unlock()
+
+	code := CLOSE_REASON_RESET
+	flushed := true
+	if policy == CLOSE_POLICY_FLUSH {
+		flushed = r.waitUntil(func() bool {
+			return udpSession.GetPendingCount() == 0
+		})
+		code = CLOSE_REASON_NORMAL
+	}
+
+	r.lock.Lock()
+	err = udpSession.Close(code)
+	r.unlock()
+
+	fclog.DEBUG("CloseSession sid=%d policy=%d", sessionId, policy)
+
+	if err == nil && !flushed {
+		return ErrCloseTimeout
+	}
+	return err
+}
+
+// Close closes the sessions and the endpoint. With CLOSE_POLICY_FLUSH it
+// fails with ErrCloseTimeout when sessions didn't finish within the close
+// timeout, the endpoint is closed all the same.
+func (r *ReliableUdp) Close(policy int) error {
+
+	select {
+	case <-r.closeChan:
+		return net.ErrClosed
+	default:
+	}
+
+	r.lock.Lock()
+	sessions := make([]*UdpSession, 0, len(r.sessionMap))
+	for _, session := range r.sessionMap {
+		session.SetClosing()
+		sessions = append(sessions, session)
+	}
+	r.sendCond.Broadcast()
+	r.unlock()
+
+	code := CLOSE_REASON_RESET
+	flushed := true
+	if policy == CLOSE_POLICY_FLUSH {
+		flushed = r.waitUntil(func() bool {
+			pending := 0
+			for _, session := range sessions {
+				pending += session.GetPendingCount()
+			}
+			return pending == 0
+		})
+		code = CLOSE_REASON_SHUTDOWN
+	}
+
+	r.lock.Lock()
+	for _, session := range r.sessionMap {
+		session.Close(code)
+	}
+	r.unlock()
+
+	if policy == CLOSE_POLICY_FLUSH {
+		flushed = r.waitUntil(func() bool {
+			return len(r.sessionMap) == 0
+		}) && flushed
+	}
+
+	r.lock.Lock()
+	for sid, session := range r.sessionMap {
+		r.removeSession(sid, session.GetCloseCode())
+	}
+	r.unlock()
+
+	r.closeOnce.Do(func() {
+		close(r.closeChan)
+	})
+
+	if r.udpSocket != nil {
+		r.udpSocket.Close()
+	}
+
+	if r.statServer != nil {
+		r.statServer.Close()
+	}
+
+	r.wg.Wait()
+
+	fclog.DEBUG("ReliableUdp closed policy=%d", policy)
+
+	if !flushed {
+		return ErrCloseTimeout
+	}
+	return nil
+}
+
+func (r *ReliableUdp) waitUntil(done func() bool) bool {
+
+	r.lock.Lock()
+	deadline := time.Now().UnixNano() + r.closeTimeout
+	r.unlock()
+
+	for time.Now().UnixNano() < deadline {
+
+		r.lock.Lock()
+		ok := done()
+		r.unlock()
+
+		if ok {
+			return true
+		}
+
+		time.Sleep(1000000 * 10)
+	}
+
+	fclog.INFO("Close wait timeout")
+	return false
+}
+
+func (r *ReliableUdp) GetEncrypt() *RudpEncrypt {
+	return &r.encrypt
+}
+
+// SetPacketCodec replaces the codec framing every datagram. It must be set
+// before Listen or DialUDP, and the peer must use a matching codec.
+func (r *ReliableUdp) SetPacketCodec(codec PacketCodec) error {
+	if codec == nil {
+		fclog.ERROR("SetPacketCodec error! codec is nil")
+		return ErrInvalidArgument
+	}
+
+	r.codecLock.Lock()
+	defer r.codecLock.Unlock()
+
+	r.codec = codec
+	return nil
+}
+
+func (r *ReliableUdp) GetPacketCodec() PacketCodec {
+	return r.getCodec()
+}
+
+// getCodec returns the codec under its own lock, it is used with and
+// without the endpoint lock held. The lock guards the checksum setting too.
+func (r *ReliableUdp) getCodec() PacketCodec {
+	r.codecLock.RLock()
+	defer r.codecLock.RUnlock()
+
+	return r.codec
+}
+
+func (r *ReliableUdp) encodePacket(sid int64, b []byte) []byte {
+
+	encodeData := r.getCodec().EncodePacket(sid, b)
+	if encodeData == nil {
+		return nil
+	}
+
+	if r.getChecksum() != CHECKSUM_NONE {
+		encodeData = appendChecksum(encodeData)
+	}
+
+	return encodeData
+}
+
+// SetSeqBits selects 16 or 32 bit sequence numbers for sessions created
+// later. 32 bit sequences are used only when the peer enables them too,
+// otherwise the session falls back to 16 bits.
+func (r *ReliableUdp) SetSeqBits(bits int) error {
+	if !isSeqBits(bits) {
+		fclog.ERROR("SetSeqBits error! bits=%d", bits)
+		return ErrInvalidArgument
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.seqBits = bits
+
+	return nil
+}
+
+// SetChecksum adds a CRC32-C trailer to every datagram and drops received
+// ones that don't match. Both peers must use the same setting.
+func (r *ReliableUdp) SetChecksum(checksum int) error {
+	if !isChecksum(checksum) {
+		fclog.ERROR("SetChecksum error! checksum=%d", checksum)
+		return ErrInvalidArgument
+	}
+
+	r.codecLock.Lock()
+	defer r.codecLock.Unlock()
+
+	r.checksum = checksum
+
+	return nil
+}
+
+func (r *ReliableUdp) getChecksum() int {
+	r.codecLock.RLock()
+	defer r.codecLock.RUnlock()
+
+	return r.checksum
+}
+
+func (r *ReliableUdp) GetCorruptCount() int64 {
+	return atomic.LoadInt64(&r.statCorrupt)
+}
+
+func (r *ReliableUdp) addCodecSession(sid int64, initiator bool) {
+	sessionCodec, ok := r.getCodec().(SessionCodec)
+	if ok {
+		sessionCodec.AddSession(sid, initiator)
+	}
+}
+
+func (r *ReliableUdp) setCodecSessionKey(sid int64, key []byte) {
+	sessionCodec, ok := r.getCodec().(SessionCodec)
+	if !ok {
+		fclog.DEBUG("Packet codec keeps no session key sid=%d", sid)
+		return
+	}
+
+	err := sessionCodec.SetSessionKey(sid, key)
+	if err != nil {
+		fclog.ERROR("Set session key error! sid=%d err=%s", sid, err.Error())
+	}
+}
+
+func (r *ReliableUdp) SetEncryptMode(mode int) error {
+	return r.encrypt.SetMode(mode)
+}
+
+func (r *ReliableUdp) SetEncryptKey(key []byte) error {
+	return r.encrypt.SetKey(key)
+}
+
+// SetKeyExchange enables the X25519 registration handshake. It switches the
+// endpoint to AEAD mode, both peers must enable it. Without SetPsk the
+// exchange is unauthenticated and open to a man in the middle, it only
+// protects against passive observers.
+func (r *ReliableUdp) SetKeyExchange(enable bool) error {
+	r.handshake.SetKeyExchange(enable)
+	if enable {
+		return r.enableHandshakeEncrypt()
+	}
+	return nil
+}
+
+// SetPsk authenticates registrations with a pre-shared key. It switches the
+// endpoint to AEAD mode, peers must share the identity and key.
+func (r *ReliableUdp) SetPsk(identity string, key []byte) error {
+	if len(identity) == 0 || len(key) == 0 {
+		fclog.ERROR("SetPsk error! identity=%s key len=%d", identity, len(key))
+		return ErrInvalidArgument
+	}
+
+	r.handshake.SetPsk(identity, key)
+	return r.enableHandshakeEncrypt()
+}
+
+func (r *ReliableUdp) enableHandshakeEncrypt() error {
+	err := r.encrypt.SetMode(ENCRYPT_MODE_AEAD)
+	if err != nil {
+		return err
+	}
+	r.encrypt.SetPublicInitialKey(true)
+	return nil
+}
+
+func (r *ReliableUdp) Stat(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stat", r.StatFunc)
+	r.statServer = &http.Server{Addr: addr, Handler: mux}
+
+	r.wg.Add(2)
+	go r.StatCalc()
+	go r.StatRun()
+}
+
+func (r *ReliableUdp) StatRun() {
+
+	defer r.wg.Done()
+
+	err := r.statServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		fclog.ERROR("Stat server error! addr=%s err=%s", r.statServer.Addr, err.Error())
+	}
+}
+
+func (r *ReliableUdp) StatCalc() {
+
+	defer r.wg.Done()
+
+	for {
+
+		select {
+		case <-time.After(1000000 * 5000):
+		case <-r.closeChan:
+			return
+		}
+
+		r.lock.Lock()
+
+		var statInfo StatInfo
+		statInfo.Count = len(r.sessionMap)
+		statInfo.Corrupt = r.GetCorruptCount()
+		statInfo.Sessions = make([]StatItem, 0)
+
+		for k, session := range r.sessionMap {
+			var item StatItem
+			item.SessionId = int(k)
+			item.Lossrate = session.GetLossrate()
+			item.Retransmissionrate = session.GetRetransmissionrate()
+			item.Srtt = session.GetSrtt() / 1000000
+			item.Rto = session.GetRto() / 1000000
+			item.Cwnd = session.GetCongestionWindow()
+			item.Pmtu = session.GetPmtu()
+			statInfo.Sessions = append(statInfo.Sessions, item)
+		}
+
+		r.unlock()
+
+		data, err := json.Marshal(statInfo)
+		if err == nil {
+			r.setStatData(data)
+		} else {
+			fclog.ERROR("Marshal json err! err=%s", err.Error())
+		}
+	}
+}
+
+func (r *ReliableUdp) setStatData(data []byte) {
+	r.statLock.Lock()
+	r.statData = data
+	r.statLock.Unlock()
+}
+
+func (r *ReliableUdp) StatFunc(w http.ResponseWriter, req *http.Request) {
+	r.statLock.Lock()
+	data := r.statData
+	r.statLock.Unlock()
+
+	w.Write(data)
 }
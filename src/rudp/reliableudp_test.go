@@ -0,0 +1,1304 @@
+package rudp
+
+import "io"
+import "net"
+import "os"
+import "sync"
+import "bytes"
+import "testing"
+import "udp"
+import "time"
+import "rudpproto"
+import "github.com/golang/protobuf/proto"
+
+type testInter struct {
+	RudpInterBase
+	lock    sync.Mutex
+	created []int
+	closed  []int
+	errs    []int
+	recv    [][]byte
+	msgs    [][]byte
+	streams map[int32][][]byte
+	order   []int32
+	fins    []int32
+	drained int
+	addrs   []int
+	onDrain func(sessionId int64)
+}
+
+func (t *testInter) OnSessionCreate(sessionId int64, code int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.created = append(t.created, code)
+}
+
+func (t *testInter) OnSessionClose(sessionId int64, code int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.closed = append(t.closed, code)
+}
+
+func (t *testInter) OnSessionError(sessionId int64, code int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.errs = append(t.errs, code)
+}
+
+func (t *testInter) OnRecv(sessionId int64, b []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.recv = append(t.recv, append([]byte{}, b...))
+}
+
+func (t *testInter) OnMessage(sessionId int64, b []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.msgs = append(t.msgs, append([]byte{}, b...))
+}
+
+func (t *testInter) OnStreamMessage(sessionId int64, streamId int32, b []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.streams == nil {
+		t.streams = make(map[int32][][]byte)
+	}
+	t.streams[streamId] = append(t.streams[streamId], append([]byte{}, b...))
+	t.order = append(t.order, streamId)
+}
+
+func (t *testInter) OnStreamClose(sessionId int64, streamId int32) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.fins = append(t.fins, streamId)
+}
+
+func (t *testInter) OnSendDrained(sessionId int64) {
+	t.lock.Lock()
+	t.drained += 1
+	onDrain := t.onDrain
+	t.lock.Unlock()
+
+	if onDrain != nil {
+		onDrain(sessionId)
+	}
+}
+
+func (t *testInter) OnPeerAddrChange(sessionId int64, ip string, port int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.addrs = append(t.addrs, port)
+}
+
+// count returns the number of events f reads, under the lock.
+func (t *testInter) count(f func() int) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return f()
+}
+
+// dropCodec drops the received datagrams listed in drop, counted from the
+// moment it is armed, and keeps the ones it lets through.
+type dropCodec struct {
+	*RudpEncrypt
+	lock    sync.Mutex
+	armed   bool
+	n       int
+	drop    map[int]bool
+	every   int
+	packets [][]byte
+}
+
+func (d *dropCodec) IsValidPacket(b []byte) bool {
+	d.lock.Lock()
+	drop := false
+	if d.armed {
+		d.n += 1
+		drop = d.drop[d.n] || (d.every > 0 && d.n%d.every == 0)
+		if !drop {
+			d.packets = append(d.packets, append([]byte{}, b...))
+		}
+	}
+	d.lock.Unlock()
+
+	if drop {
+		return false
+	}
+
+	return d.RudpEncrypt.IsValidPacket(b)
+}
+
+func (d *dropCodec) arm(drop map[int]bool, every int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.armed = true
+	d.n = 0
+	d.drop = drop
+	d.every = every
+}
+
+type testPair struct {
+	srv      *ReliableUdp
+	cli      *ReliableUdp
+	srvInter *testInter
+	cliInter *testInter
+	srvCodec *dropCodec
+	sid      int64
+}
+
+func freePort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// newTestPair connects two endpoints on loopback. setup, when set, configures
+// both before they listen.
+func newTestPair(t *testing.T, setup func(r *ReliableUdp)) *testPair {
+
+	p := new(testPair)
+	p.srvInter = new(testInter)
+	p.cliInter = new(testInter)
+
+	p.srv = NewReliableUdp()
+	p.srvCodec = &dropCodec{RudpEncrypt: p.srv.GetEncrypt()}
+	p.srv.SetPacketCodec(p.srvCodec)
+	p.srv.SetUdpInterface(p.srvInter)
+	p.cli = NewReliableUdp()
+	p.cli.SetUdpInterface(p.cliInter)
+	if setup != nil {
+		setup(p.srv)
+		setup(p.cli)
+	}
+
+	port := freePort(t)
+	if err := p.srv.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.cli.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	sid, err := p.cli.CreateSession("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.sid = sid
+
+	waitFor(t, "session create", func() bool {
+		return p.cliInter.count(func() int { return len(p.cliInter.created) }) > 0
+	})
+	if p.cliInter.created[0] != UDP_SESSION_RS_OK {
+		t.Fatalf("session create code=%d", p.cliInter.created[0])
+	}
+
+	t.Cleanup(func() {
+		p.cli.Close(CLOSE_POLICY_ABANDON)
+		p.srv.Close(CLOSE_POLICY_ABANDON)
+	})
+
+	return p
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func sessionCount(r *ReliableUdp) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.sessionMap)
+}
+
+func TestSendDataInOrder(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	for i := 0; i < 100; i++ {
+		if err := p.cli.SendData(p.sid, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 100
+	})
+	for i, b := range p.srvInter.recv {
+		if len(b) != 1 || b[0] != byte(i) {
+			t.Fatalf("packet %d out of order: %v", i, b)
+		}
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	if err := p.cli.SendData(p.sid, []byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.cli.CloseSession(p.sid, CLOSE_POLICY_FLUSH); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "close", func() bool {
+		return sessionCount(p.srv) == 0 && sessionCount(p.cli) == 0
+	})
+	waitFor(t, "close events", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.closed) }) > 0 &&
+			p.cliInter.count(func() int { return len(p.cliInter.closed) }) > 0
+	})
+	if n := p.srvInter.count(func() int { return len(p.srvInter.recv) }); n != 1 {
+		t.Fatalf("data before close lost, got %d", n)
+	}
+	if len(p.srvInter.closed) != 1 || p.srvInter.closed[0] != CLOSE_REASON_NORMAL {
+		t.Fatalf("server close codes %v", p.srvInter.closed)
+	}
+	if len(p.cliInter.closed) != 1 {
+		t.Fatalf("client close codes %v", p.cliInter.closed)
+	}
+	if err := p.cli.SendData(p.sid, []byte("late")); err != ErrUnknownSession {
+		t.Fatalf("send after close err=%v", err)
+	}
+}
+
+func TestSackFastRetransmit(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	// Timeouts far above the test time leave only fast retransmit to recover.
+	p.cli.SetRtoBounds(p.sid, 5000, 10000)
+	p.srvCodec.arm(map[int]bool{1: true, 5: true}, 0)
+
+	for i := 0; i < 20; i++ {
+		if err := p.cli.SendData(p.sid, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 20
+	})
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("recovered by timeout, not fast retransmit: %v", time.Since(start))
+	}
+	for i, b := range p.srvInter.recv {
+		if b[0] != byte(i) {
+			t.Fatalf("packet %d out of order: %v", i, b)
+		}
+	}
+
+	p.cli.lock.Lock()
+	retrans := p.cli.sessionMap[p.sid].sendBuf.GetRetransCount()
+	p.cli.lock.Unlock()
+	if retrans < 2 {
+		t.Fatalf("retransmitted %d packets", retrans)
+	}
+}
+
+func TestMessageFragmentation(t *testing.T) {
+	p := newTestPair(t, nil)
+	p.srvCodec.arm(nil, 7)
+
+	msg := make([]byte, 20*1024)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+	if err := p.cli.SendMessage(p.sid, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.cli.SendMessage(p.sid, []byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "messages", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.msgs) }) == 2
+	})
+	if string(p.srvInter.msgs[0]) != string(msg) || string(p.srvInter.msgs[1]) != "tail" {
+		t.Fatalf("messages corrupted len=%d,%d", len(p.srvInter.msgs[0]), len(p.srvInter.msgs[1]))
+	}
+}
+
+func TestForgedCloseAndDataIgnored(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	r := NewReliableUdp()
+	codec := &dropCodec{RudpEncrypt: r.GetEncrypt()}
+	r.SetPacketCodec(codec)
+	if err := r.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(CLOSE_POLICY_ABANDON)
+	codec.arm(nil, 0)
+
+	port := p.srv.udpSocket.GetPort()
+	for _, sid := range []int64{p.sid + 1, p.sid} {
+		var msg rudpmsg.RudpMsgClose
+		msg.Seq = proto.Int64(0)
+		msg.Sid = proto.Int64(sid)
+		msg.Code = proto.Int64(CLOSE_REASON_NORMAL)
+		r.sendMsg(sid, &msg, rudpmsg.RudpMsgType_MSG_RUDP_CLOSE, "127.0.0.1", port)
+	}
+
+	var data rudpmsg.RudpMsgData
+	data.Seq = proto.Int64(0)
+	data.Sid = proto.Int64(p.sid + 1)
+	data.Data = []byte("spoofed")
+	r.sendMsg(p.sid+1, &data, rudpmsg.RudpMsgType_MSG_RUDP_DATA, "127.0.0.1", port)
+
+	time.Sleep(200 * time.Millisecond)
+
+	if sessionCount(p.srv) != 1 {
+		t.Fatal("session closed by a close from a foreign address")
+	}
+	codec.lock.Lock()
+	n := codec.n
+	codec.lock.Unlock()
+	if n != 0 {
+		t.Fatalf("got %d answers to forged packets", n)
+	}
+
+	if err := p.cli.SendData(p.sid, []byte("alive")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 1
+	})
+}
+
+// newPskEndpoint listens with PSK authentication and key exchange, port 0
+// leaves the endpoint unbound.
+func newPskEndpoint(t *testing.T, inter *testInter, port int) *ReliableUdp {
+	r := NewReliableUdp()
+	r.SetUdpInterface(inter)
+	r.SetEncryptMode(ENCRYPT_MODE_AEAD)
+	r.SetEncryptKey([]byte("0123456789abcdef0123456789abcdef"))
+	r.SetKeyExchange(true)
+	r.SetPsk("a", []byte("secret-a"))
+	t.Cleanup(func() {
+		r.Close(CLOSE_POLICY_ABANDON)
+	})
+
+	if port != 0 {
+		if err := r.Listen("127.0.0.1", port); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return r
+}
+
+func TestSendBeforeRegisterCompletes(t *testing.T) {
+	srvInter := new(testInter)
+	cliInter := new(testInter)
+	port := freePort(t)
+	srv := newPskEndpoint(t, srvInter, 0)
+	cli := newPskEndpoint(t, cliInter, freePort(t))
+
+	// The server isn't listening yet, the registration can't complete.
+	sid, err := cli.CreateSession("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := cli.SendData(sid, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cli.SendDataMode(sid, []byte("x"), DELIVERY_UNRELIABLE); err != ErrNotEstablished {
+		t.Fatalf("unreliable send before registration err=%v", err)
+	}
+	cli.SetWindowPolicy(sid, WINDOW_POLICY_ERROR, 0)
+	if err := cli.SendData(sid, []byte("x")); err != ErrNotEstablished {
+		t.Fatalf("send before registration err=%v", err)
+	}
+
+	if err := srv.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "session create", func() bool {
+		return cliInter.count(func() int { return len(cliInter.created) }) > 0
+	})
+	if cliInter.created[0] != UDP_SESSION_RS_OK {
+		t.Fatalf("session create code=%d", cliInter.created[0])
+	}
+
+	waitFor(t, "data", func() bool {
+		return srvInter.count(func() int { return len(srvInter.recv) }) == 3
+	})
+	for i, b := range srvInter.recv {
+		if len(b) != 1 || b[0] != byte(i) {
+			t.Fatalf("packet %d out of order: %v", i, b)
+		}
+	}
+}
+
+func TestUnauthenticatedRegisterFailureIgnored(t *testing.T) {
+	cliInter := new(testInter)
+	port := freePort(t)
+	srv := newPskEndpoint(t, new(testInter), 0)
+	cli := newPskEndpoint(t, cliInter, freePort(t))
+	forger := newPskEndpoint(t, new(testInter), freePort(t))
+
+	sid, err := cli.CreateSession("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cliPort := cli.udpSocket.GetPort()
+	for _, code := range []int64{REG_RS_CODE_AUTH_FAILED, REG_RS_CODE_OK} {
+		var msg rudpmsg.RudpMsgRegRs
+		msg.Seq = proto.Int64(0)
+		msg.Sid = proto.Int64(sid)
+		msg.Code = proto.Int64(code)
+		msg.Nonce = make([]byte, HANDSHAKE_NONCE_LEN)
+		forger.sendMsg(sid, &msg, rudpmsg.RudpMsgType_MSG_RUDP_REG_RS, "127.0.0.1", cliPort)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if sessionCount(cli) != 1 || cliInter.count(func() int { return len(cliInter.created) }) != 0 {
+		t.Fatal("registration ended by a forged response")
+	}
+
+	if err := srv.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "session create", func() bool {
+		return cliInter.count(func() int { return len(cliInter.created) }) > 0
+	})
+	if cliInter.created[0] != UDP_SESSION_RS_OK {
+		t.Fatalf("session create code=%d", cliInter.created[0])
+	}
+}
+
+func TestRegisterDeadline(t *testing.T) {
+	srv := NewReliableUdp()
+	srv.SetUdpInterface(new(testInter))
+	srv.SetPsk("b", []byte("secret-b"))
+	cliInter := new(testInter)
+	cli := NewReliableUdp()
+	cli.SetUdpInterface(cliInter)
+	cli.SetPsk("a", []byte("secret-a"))
+	cli.SetDialTimeout(300)
+	defer cli.Close(CLOSE_POLICY_ABANDON)
+	defer srv.Close(CLOSE_POLICY_ABANDON)
+
+	port := freePort(t)
+	if err := srv.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server rejects the PSK with a failure code the client can't
+	// trust, the registration ends at its deadline.
+	if _, err := cli.CreateSession("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "session create", func() bool {
+		return cliInter.count(func() int { return len(cliInter.created) }) > 0
+	})
+	if cliInter.created[0] != UDP_SESSION_RS_ERR || sessionCount(cli) != 0 {
+		t.Fatalf("session create code=%d sessions=%d", cliInter.created[0], sessionCount(cli))
+	}
+	cli.lock.Lock()
+	timers := cli.timer.Len()
+	cli.lock.Unlock()
+	if timers != 0 {
+		t.Fatalf("timer entries=%d after the deadline", timers)
+	}
+
+	if _, err := cli.Dial("127.0.0.1", port); err != os.ErrDeadlineExceeded {
+		t.Fatalf("Dial err=%v", err)
+	}
+}
+
+func TestKeepaliveEviction(t *testing.T) {
+	p := newTestPair(t, func(r *ReliableUdp) {
+		r.SetDefaultKeepalive(50, 300)
+	})
+
+	time.Sleep(500 * time.Millisecond)
+	if sessionCount(p.srv) != 1 {
+		t.Fatal("idle session evicted while the peer answers PING")
+	}
+
+	p.cli.udpSocket.Close()
+	waitFor(t, "eviction", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.closed) }) > 0
+	})
+
+	p.srvInter.lock.Lock()
+	defer p.srvInter.lock.Unlock()
+	if len(p.srvInter.errs) != 1 || p.srvInter.errs[0] != UDP_SESSION_ERR_PEER_TIMEOUT {
+		t.Fatalf("session errors %v", p.srvInter.errs)
+	}
+	if len(p.srvInter.closed) != 1 || p.srvInter.closed[0] != CLOSE_REASON_TIMEOUT {
+		t.Fatalf("session close codes %v", p.srvInter.closed)
+	}
+}
+
+func TestKeepaliveOffByDefault(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	p.srv.lock.Lock()
+	session := p.srv.sessionMap[p.sid]
+	interval, timeout := session.pingInterval, session.idleTimeout
+	p.srv.lock.Unlock()
+	if interval != 0 || timeout != 0 {
+		t.Fatalf("keepalive on by default interval=%d timeout=%d", interval, timeout)
+	}
+}
+
+func TestSettersWhileRunning(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			p.srv.SetAckDelay(1 + i%5)
+			p.srv.SetChecksum(CHECKSUM_NONE)
+			p.srv.SetPacketCodec(p.srvCodec)
+			p.srv.SetDefaultKeepalive(0, 0)
+			p.srv.SetCloseTimeout(5000)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		if err := p.cli.SendData(p.sid, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	<-done
+
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 50
+	})
+}
+
+func TestNoRetransmissionFreesWindow(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	p.cli.SetMaxRetransmissionCount(p.sid, 0)
+	p.cli.SetRtoBounds(p.sid, 50, 100)
+	p.srvCodec.arm(nil, 2)
+
+	for i := 0; i < 10; i++ {
+		if err := p.cli.SendData(p.sid, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	if err := p.cli.CloseSession(p.sid, CLOSE_POLICY_FLUSH); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("flush close waited %v for lost packets", time.Since(start))
+	}
+}
+
+func TestWriteAfterAcceptWithLostRegisterResponse(t *testing.T) {
+	srv := NewReliableUdp()
+	srv.SetUdpInterface(new(testInter))
+	cli := NewReliableUdp()
+	cli.SetUdpInterface(new(testInter))
+	codec := &dropCodec{RudpEncrypt: cli.GetEncrypt()}
+	cli.SetPacketCodec(codec)
+	defer cli.Close(CLOSE_POLICY_ABANDON)
+	defer srv.Close(CLOSE_POLICY_ABANDON)
+
+	port := freePort(t)
+	if err := srv.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := srv.NewListener(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ack of the registration and the response are lost, the client
+	// registers again while the server already sends data.
+	codec.arm(map[int]bool{1: true, 2: true}, 0)
+
+	dialed := make(chan net.Conn, 1)
+	go func() {
+		conn, err := cli.Dial("127.0.0.1", port)
+		if err != nil {
+			t.Error(err)
+		}
+		dialed <- conn
+	}()
+
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accepted.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := <-dialed
+	if conn == nil {
+		t.FailNow()
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("read %q", b)
+	}
+}
+
+func TestConnReadBackpressure(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	l, err := p.srv.NewListener(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := p.cli.Dial("127.0.0.1", p.srv.udpSocket.GetPort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sconn := accepted.(*RudpConn)
+
+	// More than the conn and the receive window of the session can hold.
+	payload := make([]byte, CONN_READ_BUFFER_SIZE+2*RECV_WINDOW_DEFAULT*DATAGRAM_SIZE_DEFAULT)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(payload)
+		written <- err
+	}()
+
+	// Nobody reads: the conn buffers up to its limit and the writer stalls.
+	time.Sleep(500 * time.Millisecond)
+	sconn.lock.Lock()
+	buffered := len(sconn.readBuf)
+	sconn.lock.Unlock()
+	if buffered > CONN_READ_BUFFER_SIZE+DATAGRAM_SIZE_DEFAULT {
+		t.Fatalf("conn buffered %d bytes", buffered)
+	}
+	select {
+	case <-written:
+		t.Fatal("write completed while the reader was stalled")
+	default:
+	}
+
+	b := make([]byte, len(payload))
+	if _, err := io.ReadFull(sconn, b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, payload) {
+		t.Fatal("payload corrupted")
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenerCloseAbandonsPending(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	l, err := p.srv.NewListener(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.cli.Dial("127.0.0.1", p.srv.udpSocket.GetPort()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Data the peers never ack would hold up a flushing close.
+	p.srvCodec.arm(nil, 1)
+	p.srv.lock.Lock()
+	sids := make([]int64, 0)
+	for sid := range p.srv.sessionMap {
+		if sid != p.sid {
+			sids = append(sids, sid)
+		}
+	}
+	p.srv.lock.Unlock()
+	for _, sid := range sids {
+		if err := p.srv.SendData(sid, []byte("unacked")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("listener close took %v", d)
+	}
+	p.srvCodec.arm(nil, 0)
+	waitFor(t, "pending sessions closed", func() bool {
+		return sessionCount(p.srv) == 1
+	})
+}
+
+func TestUnorderedDeliveryOutOfOrder(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	// The first packet is lost, the later ones are delivered before its
+	// retransmission.
+	p.srvCodec.arm(map[int]bool{1: true}, 0)
+	for i := 0; i < 5; i++ {
+		if err := p.cli.SendDataMode(p.sid, []byte{byte(i)}, DELIVERY_RELIABLE_UNORDERED); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 5
+	})
+
+	p.srvInter.lock.Lock()
+	defer p.srvInter.lock.Unlock()
+	seen := make(map[byte]bool)
+	for _, b := range p.srvInter.recv {
+		seen[b[0]] = true
+	}
+	if len(seen) != 5 {
+		t.Fatalf("delivered %v", p.srvInter.recv)
+	}
+	if p.srvInter.recv[4][0] != 0 {
+		t.Fatalf("lost packet not delivered last %v", p.srvInter.recv)
+	}
+}
+
+func TestUnreliableNotRetransmitted(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	p.srvCodec.arm(map[int]bool{1: true}, 0)
+	for i := 0; i < 5; i++ {
+		if err := p.cli.SendDataMode(p.sid, []byte{byte(i)}, DELIVERY_UNRELIABLE); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 4
+	})
+
+	p.cli.lock.Lock()
+	rto := time.Duration(p.cli.sessionMap[p.sid].GetRto())
+	p.cli.lock.Unlock()
+	time.Sleep(2 * rto)
+
+	p.cli.lock.Lock()
+	session := p.cli.sessionMap[p.sid]
+	pending := session.GetPendingCount()
+	retrans := session.sendBuf.GetRetransCount()
+	p.cli.lock.Unlock()
+	if pending != 0 || retrans != 0 {
+		t.Fatalf("unreliable data kept for retransmission pending=%d retrans=%d", pending, retrans)
+	}
+
+	p.srvInter.lock.Lock()
+	defer p.srvInter.lock.Unlock()
+	if len(p.srvInter.recv) != 4 || p.srvInter.recv[0][0] != 1 {
+		t.Fatalf("delivered %v", p.srvInter.recv)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	r := NewReliableUdp()
+
+	if err := r.SetRecvWindow(0); err != ErrInvalidArgument {
+		t.Fatalf("SetRecvWindow(0) err=%v", err)
+	}
+	if err := r.SetMaxDatagramSize(DATAGRAM_OVERHEAD); err != ErrInvalidArgument {
+		t.Fatalf("SetMaxDatagramSize err=%v", err)
+	}
+	if err := r.SetMaxMessageSize(0); err != ErrInvalidArgument {
+		t.Fatalf("SetMaxMessageSize err=%v", err)
+	}
+	if _, err := r.GetFragmentSize(1); err != ErrUnknownSession {
+		t.Fatalf("GetFragmentSize err=%v", err)
+	}
+
+	if err := r.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewListener(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewListener(0); err != ErrListenerExists {
+		t.Fatalf("second NewListener err=%v", err)
+	}
+
+	if err := r.Close(CLOSE_POLICY_FLUSH); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(CLOSE_POLICY_FLUSH); err != net.ErrClosed {
+		t.Fatalf("second Close err=%v", err)
+	}
+}
+
+func TestInvalidSettingsRejected(t *testing.T) {
+	p := newTestPair(t, nil)
+	r := p.cli
+
+	for name, err := range map[string]error{
+		"SetDefaultDeliveryMode":      r.SetDefaultDeliveryMode(DELIVERY_DEFAULT),
+		"SetDefaultWindowPolicy":      r.SetDefaultWindowPolicy(WINDOW_POLICY_QUEUE + 1),
+		"SetDefaultCongestionControl": r.SetDefaultCongestionControl(CONGESTION_CUBIC + 1),
+		"SetSeqBits":                  r.SetSeqBits(24),
+		"SetChecksum":                 r.SetChecksum(CHECKSUM_CRC32C + 1),
+		"SetEncryptMode":              r.SetEncryptMode(ENCRYPT_MODE_AEAD + 1),
+		"SetDeliveryMode":             r.SetDeliveryMode(p.sid, DELIVERY_UNRELIABLE+1),
+		"SetWindowPolicy":             r.SetWindowPolicy(p.sid, -1, 0),
+		"SetWindowPolicy backlog":     r.SetWindowPolicy(p.sid, WINDOW_POLICY_QUEUE, -1),
+		"SetCongestionControl":        r.SetCongestionControl(p.sid, -1),
+		"SetRtoBounds zero":           r.SetRtoBounds(p.sid, 0, 0),
+		"SetRtoBounds min above max":  r.SetRtoBounds(p.sid, 500, 100),
+		"SendDataMode":                r.SendDataMode(p.sid, []byte("x"), DELIVERY_UNRELIABLE+1),
+		"SetUdpInterface":             r.SetUdpInterface(nil),
+		"SetPacketCodec":              r.SetPacketCodec(nil),
+		"SetDialTimeout":              r.SetDialTimeout(0),
+		"SetCloseTimeout":             r.SetCloseTimeout(-1),
+		"SetMaxStreams":               r.SetMaxStreams(-1),
+		"SetDefaultKeepalive":         r.SetDefaultKeepalive(-1, 0),
+		"SetAckDelay zero":            r.SetAckDelay(0),
+		"SetAckDelay above max":       r.SetAckDelay(int(ACK_DELAY_MAX/1000000) + 1),
+		"SetPsk identity":             r.SetPsk("", []byte("secret-a")),
+		"SetPsk key":                  r.SetPsk("a", nil),
+		"SetKeepalive":                r.SetKeepalive(p.sid, 0, -1),
+		"SetFastRetransThreshold":     r.SetFastRetransThreshold(p.sid, -1),
+		"SetMaxRetransmissionCount":   r.SetMaxRetransmissionCount(p.sid, -2),
+		"SetPacingRate":               r.SetPacingRate(p.sid, PACING_RATE_OFF-1),
+	} {
+		if err != ErrInvalidArgument {
+			t.Errorf("%s err=%v", name, err)
+		}
+	}
+
+	// The settings in use are kept.
+	if r.GetEncrypt().GetMode() != ENCRYPT_MODE_LEGACY || r.getChecksum() != CHECKSUM_NONE {
+		t.Fatal("rejected setting applied")
+	}
+	r.lock.Lock()
+	applied := r.udpInter != p.cliInter || r.dialTimeout != CONN_DIAL_TIMEOUT || r.ackDelay != ACK_DELAY_DEFAULT || r.maxStreams != STREAM_MAX_DEFAULT
+	r.lock.Unlock()
+	if applied || r.getCodec() == nil || r.handshake.IsAuthenticated() {
+		t.Fatal("rejected setting applied")
+	}
+	if err := r.SendData(p.sid, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 1
+	})
+}
+
+func testSeqWrap(t *testing.T, bits int, start int64) {
+	p := newTestPair(t, func(r *ReliableUdp) { r.SetSeqBits(bits) })
+
+	p.cli.lock.Lock()
+	cs := p.cli.sessionMap[p.sid]
+	if cs.GetSeqSpace().GetBits() != bits {
+		p.cli.lock.Unlock()
+		t.Fatalf("seq bits=%d", cs.GetSeqSpace().GetBits())
+	}
+	cs.sendSeq = start
+	p.cli.lock.Unlock()
+	p.srv.lock.Lock()
+	p.srv.sessionMap[p.sid].recvBuf.nextSeq = start
+	p.srv.lock.Unlock()
+
+	// Lose packets on both sides of the wrap.
+	p.srvCodec.arm(map[int]bool{50: true, 120: true}, 0)
+
+	for i := 0; i < 300; i++ {
+		if err := p.cli.SendData(p.sid, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "data over the wrap", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 300
+	})
+	for i, b := range p.srvInter.recv {
+		if b[0] != byte(i) {
+			t.Fatalf("packet %d out of order", i)
+		}
+	}
+	waitFor(t, "acks over the wrap", func() bool {
+		p.cli.lock.Lock()
+		defer p.cli.lock.Unlock()
+		return cs.GetPendingCount() == 0
+	})
+}
+
+func TestSeqWrap16(t *testing.T) {
+	testSeqWrap(t, SEQ_BITS_16, SEQ_MAX_INDEX-100)
+}
+
+func TestSeqWrap32(t *testing.T) {
+	testSeqWrap(t, SEQ_BITS_32, SEQ_MAX_INDEX_32-100)
+}
+
+func TestMessageLargerThanPeerWindow(t *testing.T) {
+	p := newTestPair(t, func(r *ReliableUdp) { r.SetRecvWindow(4) })
+
+	if err := p.cli.SendMessage(p.sid, make([]byte, 20*1024)); err != ErrMessageTooLarge {
+		t.Fatalf("SendMessage err=%v", err)
+	}
+
+	fragSize, err := p.cli.GetFragmentSize(p.sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := bytes.Repeat([]byte{0x5a}, 4*fragSize)
+	if err := p.cli.SendMessage(p.sid, msg); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "message", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.msgs) }) == 1
+	})
+	if !bytes.Equal(p.srvInter.msgs[0], msg) {
+		t.Fatal("message corrupted")
+	}
+}
+
+func TestMessageNotInterleavedWithStream(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	if err := p.cli.OpenStream(p.sid, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// More fragments than the initial congestion window, the rest is queued
+	// behind the stream data of higher priority sent next.
+	msg := make([]byte, 14*1300)
+	for i := range msg {
+		msg[i] = byte(i * 3)
+	}
+	if err := p.cli.SendMessage(p.sid, msg); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := p.cli.SendStream(p.sid, 1, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "message and stream data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.msgs) + len(p.srvInter.streams[1]) }) == 6
+	})
+	if !bytes.Equal(p.srvInter.msgs[0], msg) {
+		t.Fatal("message corrupted")
+	}
+}
+
+func TestStreamMessagesNotInterleaved(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	if err := p.cli.OpenStream(p.sid, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.cli.OpenStream(p.sid, 2, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message of the lower priority stream outgrows the initial
+	// congestion window, the one queued next on the other stream must wait
+	// for its last fragment.
+	low := make([]byte, 14*1300)
+	high := make([]byte, 4*1300)
+	for i := range low {
+		low[i] = byte(i * 3)
+	}
+	for i := range high {
+		high[i] = byte(i * 7)
+	}
+	if err := p.cli.SendStream(p.sid, 1, low); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.cli.SendStream(p.sid, 2, high); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "stream messages", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.streams[1]) + len(p.srvInter.streams[2]) }) == 2
+	})
+	if !bytes.Equal(p.srvInter.streams[1][0], low) || !bytes.Equal(p.srvInter.streams[2][0], high) {
+		t.Fatal("stream message corrupted")
+	}
+}
+
+func TestStreamPriority(t *testing.T) {
+	r := NewReliableUdp()
+	s := new(UdpSession)
+	s.Init(1, "127.0.0.1", 1, nil, r)
+	low := s.newStream(1, 1)
+	high := s.newStream(2, 10)
+
+	queue := func(q *[]*BacklogItem, stream int32, frag int32, total int32) {
+		*q = append(*q, &BacklogItem{stream: stream, frag: frag, total: total})
+		s.backlogCount += 1
+	}
+	queue(&low.backlog, 1, 0, 0)
+	queue(&s.backlog, STREAM_DEFAULT, 0, 0)
+	queue(&high.backlog, 2, 0, 0)
+	queue(&low.backlog, 1, 0, 0)
+
+	order := make([]int32, 0)
+	for s.backlogCount > 0 {
+		order = append(order, s.popBacklog().stream)
+	}
+	if !equalStreams(order, []int32{2, 1, 1, STREAM_DEFAULT}) {
+		t.Fatalf("stream order %v", order)
+	}
+
+	// The rest of a session message goes out before any stream.
+	queue(&s.backlog, STREAM_DEFAULT, 1, 3)
+	queue(&high.backlog, 2, 0, 0)
+	queue(&s.backlog, STREAM_DEFAULT, 2, 3)
+
+	order = order[:0]
+	for s.backlogCount > 0 {
+		order = append(order, s.popBacklog().stream)
+	}
+	if !equalStreams(order, []int32{STREAM_DEFAULT, STREAM_DEFAULT, 2}) {
+		t.Fatalf("stream order %v", order)
+	}
+
+	// So does the rest of a stream message, ahead of higher priorities.
+	queue(&low.backlog, 1, 1, 3)
+	queue(&high.backlog, 2, 0, 2)
+	queue(&low.backlog, 1, 2, 3)
+	queue(&high.backlog, 2, 1, 2)
+
+	order = order[:0]
+	for s.backlogCount > 0 {
+		order = append(order, s.popBacklog().stream)
+	}
+	if !equalStreams(order, []int32{1, 1, 2, 2}) {
+		t.Fatalf("stream order %v", order)
+	}
+}
+
+func equalStreams(a []int32, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStreamRingsShareWindow(t *testing.T) {
+	r := NewReliableUdp()
+	r.SetRecvWindow(64)
+	r.SetMaxStreams(8)
+	s := new(UdpSession)
+	s.Init(1, "127.0.0.1", 1, nil, r)
+
+	// Opening a stream costs nothing until data waits on it.
+	if !s.OnStreamRecv(0, 1, 0, []byte{0}, 0, 0, false) {
+		t.Fatal("stream data rejected")
+	}
+	if size := s.streams[1].recvBuf.GetSize(); size > STREAM_RING_MIN {
+		t.Fatalf("stream ring size=%d", size)
+	}
+
+	// and its ring is released once drained.
+	if _, _, _, ok := s.ReadStreamCheck(); !ok {
+		t.Fatal("stream data not delivered")
+	}
+	s.ReadStreamCheck()
+	if size := s.streams[1].recvBuf.GetSize(); size != 0 {
+		t.Fatalf("drained stream ring size=%d", size)
+	}
+
+	// Data far ahead on every stream grows the rings within one window.
+	var seq int64 = 1
+	accepted := 0
+	for id := int32(1); id <= 8; id++ {
+		if s.OnStreamRecv(seq, id, 40, []byte{0}, 0, 0, false) {
+			accepted += 1
+		} else if s.recvBuf.IsReceived(seq) {
+			t.Fatalf("rejected seq=%d recorded", seq)
+		}
+		seq += 1
+	}
+	if accepted == 0 || accepted == 8 {
+		t.Fatalf("accepted=%d", accepted)
+	}
+	total := 0
+	for _, stream := range s.streams {
+		total += stream.recvBuf.GetSize()
+	}
+	if total > 64 {
+		t.Fatalf("stream rings hold %d slots", total)
+	}
+
+	// Streams over the limit are neither opened nor recorded.
+	if s.OnStreamRecv(seq, 9, 0, []byte{0}, 0, 0, false) || s.recvBuf.IsReceived(seq) {
+		t.Fatal("data of stream over limit accepted")
+	}
+	if s.GetStream(9) != nil {
+		t.Fatal("stream over limit opened")
+	}
+}
+
+func TestSendDrainedCallbackSends(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	sent := make(chan error, 1)
+	var once sync.Once
+	p.cliInter.lock.Lock()
+	drained := p.cliInter.drained
+	p.cliInter.onDrain = func(sessionId int64) {
+		once.Do(func() {
+			sent <- p.cli.SendData(sessionId, []byte("more"))
+		})
+	}
+	p.cliInter.lock.Unlock()
+
+	if err := p.cli.SendData(p.sid, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendData from OnSendDrained blocked")
+	}
+
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 2
+	})
+	waitFor(t, "drained twice", func() bool {
+		return p.cliInter.count(func() int { return p.cliInter.drained }) >= drained+2
+	})
+}
+
+func TestPeerAddrChangeValidated(t *testing.T) {
+	p := newTestPair(t, nil)
+
+	// The client moves to a new port, the server follows once the client
+	// answered its challenge from there.
+	port := freePort(t)
+	sock := new(udpsocket.UdpSocket)
+	sock.SetUdpReceiver(p.cli)
+	if err := sock.Listen("127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	p.cli.lock.Lock()
+	old := p.cli.udpSocket
+	p.cli.udpSocket = sock
+	p.cli.sessionMap[p.sid].udpSocket = sock
+	p.cli.lock.Unlock()
+	old.Close()
+
+	if err := p.cli.SendData(p.sid, []byte("moved")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "address change", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.addrs) }) == 1
+	})
+	if p.srvInter.addrs[0] != port {
+		t.Fatalf("peer moved to port %d, want %d", p.srvInter.addrs[0], port)
+	}
+	waitFor(t, "acks on the new address", func() bool {
+		p.cli.lock.Lock()
+		defer p.cli.lock.Unlock()
+		return p.cli.sessionMap[p.sid].GetPendingCount() == 0
+	})
+
+	// A ping forged from another address gets a challenge nobody answers.
+	r := NewReliableUdp()
+	if err := r.Listen("127.0.0.1", freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(CLOSE_POLICY_ABANDON)
+
+	var ping rudpmsg.RudpMsgPing
+	ping.Seq = proto.Int64(0)
+	ping.Sid = proto.Int64(p.sid)
+	ping.Ts = proto.Int64(1)
+	r.sendMsg(p.sid, &ping, rudpmsg.RudpMsgType_MSG_RUDP_PING, "127.0.0.1", p.srv.udpSocket.GetPort())
+	time.Sleep(200 * time.Millisecond)
+
+	p.srv.lock.Lock()
+	addr := p.srv.sessionMap[p.sid].GetPeerAddr()
+	p.srv.lock.Unlock()
+	if addr.Port != port || p.srvInter.count(func() int { return len(p.srvInter.addrs) }) != 1 {
+		t.Fatalf("forged ping moved the session to %v", addr)
+	}
+}
+
+func TestAeadLostAckRecovers(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	p := newTestPair(t, func(r *ReliableUdp) {
+		r.SetEncryptMode(ENCRYPT_MODE_AEAD)
+		r.SetEncryptKey(key)
+	})
+
+	// The ack of the data is lost, the resend must still be accepted.
+	cliCodec := &dropCodec{RudpEncrypt: p.cli.GetEncrypt()}
+	p.cli.SetPacketCodec(cliCodec)
+	cliCodec.arm(map[int]bool{1: true}, 0)
+	p.cli.SetRtoBounds(p.sid, 50, 200)
+
+	if err := p.cli.SendData(p.sid, []byte("once")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "data", func() bool {
+		return p.srvInter.count(func() int { return len(p.srvInter.recv) }) == 1
+	})
+	waitFor(t, "ack of the resend", func() bool {
+		p.cli.lock.Lock()
+		defer p.cli.lock.Unlock()
+		return p.cli.sessionMap[p.sid].GetPendingCount() == 0
+	})
+
+	p.cli.lock.Lock()
+	retrans := p.cli.sessionMap[p.sid].sendBuf.GetRetransCount()
+	p.cli.lock.Unlock()
+	if retrans == 0 {
+		t.Fatal("ack was not lost")
+	}
+	if n := p.srvInter.count(func() int { return len(p.srvInter.recv) }); n != 1 {
+		t.Fatalf("data delivered %d times", n)
+	}
+}
@@ -0,0 +1,50 @@
+package rudp
+
+// RudpInter receives the events of a ReliableUdp endpoint. Callbacks run on
+// the endpoint goroutines once the endpoint lock is released, so they may
+// call back into the endpoint, such as SendData from OnSendDrained. Events
+// of one goroutine keep their order, callbacks should not block as the
+// goroutine waits for them.
+type RudpInter interface {
+	OnSessionCreate(sessionId int64, code int)
+	OnRecv(sessionId int64, b []byte)
+	OnMessage(sessionId int64, b []byte)
+	OnStreamMessage(sessionId int64, streamId int32, b []byte)
+	OnStreamClose(sessionId int64, streamId int32)
+	OnSessionError(sessionId int64, errCode int)
+	OnSessionClose(sessionId int64, code int)
+	OnSendDrained(sessionId int64)
+	OnPeerAddrChange(sessionId int64, ip string, port int)
+}
+
+// RudpInterBase implements every RudpInter callback as a no-op. Embed it to
+// implement only the events you need.
+type RudpInterBase struct {
+}
+
+func (b *RudpInterBase) OnSessionCreate(sessionId int64, code int) {
+}
+
+func (b *RudpInterBase) OnRecv(sessionId int64, data []byte) {
+}
+
+func (b *RudpInterBase) OnMessage(sessionId int64, data []byte) {
+}
+
+func (b *RudpInterBase) OnStreamMessage(sessionId int64, streamId int32, data []byte) {
+}
+
+func (b *RudpInterBase) OnStreamClose(sessionId int64, streamId int32) {
+}
+
+func (b *RudpInterBase) OnSessionError(sessionId int64, errCode int) {
+}
+
+func (b *RudpInterBase) OnSessionClose(sessionId int64, code int) {
+}
+
+func (b *RudpInterBase) OnSendDrained(sessionId int64) {
+}
+
+func (b *RudpInterBase) OnPeerAddrChange(sessionId int64, ip string, port int) {
+}
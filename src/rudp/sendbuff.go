@@ -1,12 +1,27 @@
 package rudp
 
 import "time"
+import "math/bits"
 import "github.com/woodywanghg/gofclog"
 
 type SendBuffItem struct {
 	ts      int64
 	data    []byte
 	retrans int
+	rto     int64
+	fast    bool
+	sealed  bool
+	timer   *timerEntry
+}
+
+type BacklogItem struct {
+	data   []byte
+	frag   int32
+	total  int32
+	mode   int32
+	stream int32
+	ssn    int64
+	fin    bool
 }
 
 type SendBuff struct {
@@ -21,15 +36,25 @@ func (s *SendBuff) Init(udpSession *UdpSession) {
 	s.seqMap = make(map[int64]*SendBuffItem, 100)
 }
 
-func (s *SendBuff) Insert(b []byte, seq int64) {
+// Insert keeps packet b of seq until it is acked. b is sealed again on every
+// resend, unless sealed is set when it was sealed once for good.
+func (s *SendBuff) Insert(b []byte, seq int64, sealed bool) {
 
 	ts := time.Now().UnixNano()
 	item := new(SendBuffItem)
 	item.ts = ts
 	item.data = b
 	item.retrans = 0
+	item.rto = s.udpSession.GetRto()
+	item.fast = false
+	item.sealed = sealed
 
+	old, have := s.seqMap[seq]
+	if have {
+		s.udpSession.CancelRetrans(old)
+	}
 	s.seqMap[seq] = item
+	s.udpSession.ScheduleRetrans(seq, item)
 
 	fclog.DEBUG("Send buffer seq=%d len=%d", seq, len(s.seqMap))
 
@@ -38,40 +63,159 @@ func (s *SendBuff) Insert(b []byte, seq int64) {
 	}
 }
 
-func (s *SendBuff) Delete(seq int64) {
+// RttSample returns the round trip time of seq measured at curTs. Packets
+// that have been retransmitted give no sample (Karn's rule).
+func (s *SendBuff) RttSample(seq int64, curTs int64) (int64, bool) {
 
-	delete(s.seqMap, seq)
+	item, have := s.seqMap[seq]
+	if !have || item.retrans > 0 {
+		return 0, false
+	}
+
+	return curTs - item.ts, true
+}
+
+func (s *SendBuff) Delete(seq int64) int {
+
+	_, have := s.seqMap[seq]
+	if !have {
+		return 0
+	}
+
+	s.remove(seq)
 
 	fclog.DEBUG("Delete send buffer data! seq=%d", seq)
 
+	return 1
 }
 
-func (s *SendBuff) Check() {
-	if s.udpSession.GetRetransCount() == 0 {
-		return
+// AckRange deletes every packet covered by a cumulative ack: all sequences
+// before cum, plus cum+1+i for every bit i set in sack. It returns the number
+// of packets removed.
+func (s *SendBuff) AckRange(cum int64, sack uint64) int {
+
+	count := 0
+	space := s.udpSession.GetSeqSpace()
+
+	for seq, _ := range s.seqMap {
+		if seq != SEQ_CONTROL && space.Less(seq, cum) {
+			s.remove(seq)
+			count += 1
+		}
 	}
 
-	curTs := time.Now().UnixNano()
+	for i := 0; i < ACK_SACK_BITS && sack != 0; i++ {
+		if sack&(1<<uint(i)) != 0 {
+			count += s.Delete(space.Add(cum, 1+int64(i)))
+		}
+	}
+
+	fclog.DEBUG("Ack range cum=%d sack=%x count=%d", cum, sack, count)
+
+	return count
+}
 
-	fclog.DEBUG("Check......")
-	for seq, v := range s.seqMap {
-		fclog.DEBUG("curTs=%d v.ts=%d sub=%d interval=%d", curTs, v.ts, curTs-v.ts, s.udpSession.GetRetransInterval())
-		if curTs-v.ts >= s.udpSession.GetRetransInterval() {
+// FastRetrans resends, without waiting for their timeout, the packets an ack
+// reports missing while at least threshold later packets have arrived. Each
+// packet is fast retransmitted once, after that only its timer resends it.
+func (s *SendBuff) FastRetrans(cum int64, sack uint64, threshold int, curTs int64) int {
 
-			s.retransCount += 1
-			v.retrans += 1
-			s.udpSession.SendRetransData(v.data)
-			fclog.DEBUG("Ack timeout retransmission interval=%d seq=%d retrans count=%d", s.udpSession.GetRetransInterval(), seq, v.retrans)
+	if threshold <= 0 || sack == 0 || s.udpSession.GetRetransCount() == 0 {
+		return 0
+	}
+
+	count := 0
+	space := s.udpSession.GetSeqSpace()
+
+	for offset := 0; offset < ACK_SACK_BITS; offset++ {
+		later := bits.OnesCount64(sack >> uint(offset))
+		if later < threshold {
+			break
+		}
+
+		if offset > 0 && sack&(1<<uint(offset-1)) != 0 {
+			continue
+		}
 
-			if s.udpSession.GetRetransCount() > 0 {
-				if v.retrans > s.udpSession.GetRetransCount() {
-					fclog.INFO("Packet invalid! rm packet.  max retransmission limit=%d", v.retrans)
-					delete(s.seqMap, seq)
-				}
-			}
+		seq := space.Add(cum, int64(offset))
+		v, have := s.seqMap[seq]
+		if !have || v.fast {
+			continue
 		}
+
+		limit := s.udpSession.GetRetransCount()
+		if limit > 0 && v.retrans >= limit {
+			continue
+		}
+
+		s.retransCount += 1
+		v.retrans += 1
+		v.fast = true
+		v.ts = curTs
+		s.udpSession.SendRetransData(v)
+		s.udpSession.ScheduleRetrans(seq, v)
+		count += 1
+
+		fclog.DEBUG("Fast retransmission seq=%d later=%d", seq, later)
 	}
-	fclog.DEBUG("Check out......")
+
+	return count
+}
+
+// Expire retransmits seq once its timeout passed and schedules it again with
+// the backed off timeout. Timer entries for packets that were acked or
+// rescheduled since are ignored. It returns true when the packet was given
+// up, which frees its place in the window.
+func (s *SendBuff) Expire(seq int64, item *SendBuffItem, deadline int64, curTs int64) bool {
+
+	v, have := s.seqMap[seq]
+	if !have || v != item || v.ts+v.rto != deadline {
+		return false
+	}
+
+	if s.udpSession.GetRetransCount() == 0 {
+		fclog.INFO("Packet timeout without retransmission, rm packet seq=%d", seq)
+		s.remove(seq)
+		return true
+	}
+
+	s.retransCount += 1
+	v.retrans += 1
+	v.ts = curTs
+	v.rto = s.udpSession.BoundRto(v.rto * 2)
+	s.udpSession.OnRetransTimeout(curTs)
+	s.udpSession.SendRetransData(v)
+	fclog.DEBUG("Ack timeout retransmission rto=%d seq=%d retrans count=%d", v.rto, seq, v.retrans)
+
+	if s.udpSession.GetRetransCount() > 0 {
+		if v.retrans > s.udpSession.GetRetransCount() {
+			fclog.INFO("Packet invalid! rm packet.  max retransmission limit=%d", v.retrans)
+			s.remove(seq)
+			return true
+		}
+	}
+
+	s.udpSession.ScheduleRetrans(seq, v)
+
+	return false
+}
+
+// remove deletes seq together with its timer entry.
+func (s *SendBuff) remove(seq int64) {
+	s.udpSession.CancelRetrans(s.seqMap[seq])
+	delete(s.seqMap, seq)
+}
+
+// CancelTimers removes the timer entries of every packet, before the buffer
+// is dropped.
+func (s *SendBuff) CancelTimers() {
+	for _, v := range s.seqMap {
+		s.udpSession.CancelRetrans(v)
+	}
+}
+
+func (s *SendBuff) GetLength() int {
+	return len(s.seqMap)
 }
 
 func (s *SendBuff) GetRetransCount() int64 {
@@ -0,0 +1,64 @@
+package rudp
+
+const (
+	SEQ_BITS_16 = 16
+	SEQ_BITS_32 = 32
+)
+
+const (
+	SEQ_MAX_INDEX_32 = 1 << 32
+)
+
+// SEQ_CONTROL is the sequence of registration and close messages. It lies
+// outside every sequence space, so their acks and resends never mix with
+// those of data.
+const (
+	SEQ_CONTROL = -1
+)
+
+// SeqSpace is the sequence number space of a session, the number of values
+// before sequences wrap to 0. Sequences are compared with serial number
+// arithmetic as in RFC 1982: a is before b when b is less than half the space
+// ahead of a.
+type SeqSpace int64
+
+func NewSeqSpace(bits int) SeqSpace {
+	if bits == SEQ_BITS_32 {
+		return SEQ_MAX_INDEX_32
+	}
+	return SEQ_MAX_INDEX
+}
+
+func isSeqBits(bits int) bool {
+	return bits == SEQ_BITS_16 || bits == SEQ_BITS_32
+}
+
+func (m SeqSpace) GetBits() int {
+	if m == SEQ_MAX_INDEX_32 {
+		return SEQ_BITS_32
+	}
+	return SEQ_BITS_16
+}
+
+func (m SeqSpace) Add(seq int64, n int64) int64 {
+	return ((seq+n)%int64(m) + int64(m)) % int64(m)
+}
+
+func (m SeqSpace) Next(seq int64) int64 {
+	return m.Add(seq, 1)
+}
+
+// Distance returns how far to is ahead of from.
+func (m SeqSpace) Distance(from int64, to int64) int64 {
+	return ((to-from)%int64(m) + int64(m)) % int64(m)
+}
+
+func (m SeqSpace) Half() int64 {
+	return int64(m) / 2
+}
+
+// Less reports whether a comes before b.
+func (m SeqSpace) Less(a int64, b int64) bool {
+	d := m.Distance(a, b)
+	return d > 0 && d < m.Half()
+}
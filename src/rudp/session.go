@@ -4,6 +4,8 @@ import "udp"
 
 import "time"
 import "net"
+import "crypto/rand"
+import "encoding/binary"
 import "rudpproto"
 import "github.com/woodywanghg/gofclog"
 import "github.com/golang/protobuf/proto"
@@ -18,14 +20,58 @@ type UdpSession struct {
 	udpSocket          *udpsocket.UdpSocket
 	reliableUdp        *ReliableUdp
 	sendSeq            int64
+	seqSpace           SeqSpace
 	retransCount       int
-	retransInterval    int64
-	readTimeout        int64
-	dstAddr            net.UDPAddr
+	srtt               int64
+	rttvar             int64
+	rto                int64
+	minRto             int64
+	maxRto             int64
+	backoffTs          int64
+	fastThreshold      int
+	peerWnd            int
+	peerRing           int
+	windowPolicy       int
+	backlog            []*BacklogItem
+	backlogCount       int
+	backlogLimit       int
+	streams            map[int32]*Stream
+	maxStreams         int
+	congestion         CongestionController
+	pacer              udpsocket.Pacer
+	pacingRate         int64
+	packetSize         int
+	pmtu               PmtuSearch
+	timeoutCount       int
+	deliveryMode       int
+	unreliableSeq      int64
+	peerUnreliableSeq  int64
+	peerUnreliable     bool
+	dstAddr            *net.UDPAddr
+	pathAddr           *net.UDPAddr
+	pathChallenge      int64
+	pathTs             int64
 	lossRate           int
 	retransmissionRate int
 	statSendCount      int64
 	statAckCount       int64
+	closing            bool
+	closed             bool
+	closeCode          int
+	closeDeadline      int64
+	closeTimer         *timerEntry
+	registerDeadline   int64
+	registerTimer      *timerEntry
+	pingInterval       int64
+	idleTimeout        int64
+	lastRecvTs         int64
+	lastPingTs         int64
+	pingSeq            int64
+	established        bool
+	handshakeState     *HandshakeState
+	ackPending         int
+	ackDeadline        int64
+	ackSeq             int64
 }
 
 func (s *UdpSession) Init(sessionId int64, dIp string, dPort int, udpSocket *udpsocket.UdpSocket, reliableUdp *ReliableUdp) {
@@ -33,22 +79,473 @@ func (s *UdpSession) Init(sessionId int64, dIp string, dPort int, udpSocket *udp
 	s.dPort = dPort
 	s.sessionId = sessionId
 	s.retransCount = -1
-	s.retransInterval = 100
-	s.readTimeout = 2000
+	s.srtt = 0
+	s.rttvar = 0
+	s.rto = RTO_INIT
+	s.minRto = RTO_MIN_DEFAULT
+	s.maxRto = RTO_MAX_DEFAULT
+	s.backoffTs = 0
+	s.fastThreshold = FAST_RETRANS_THRESHOLD
+	s.peerWnd = RECV_WINDOW_DEFAULT
+	s.peerRing = RECV_WINDOW_DEFAULT
+	s.windowPolicy = reliableUdp.windowPolicy
+	s.backlog = make([]*BacklogItem, 0)
+	s.backlogCount = 0
+	s.backlogLimit = SEND_BACKLOG_DEFAULT
+	s.streams = make(map[int32]*Stream, 0)
+	s.maxStreams = reliableUdp.maxStreams
+	s.congestion = NewCongestionController(reliableUdp.congestionAlgo)
+	s.pacingRate = PACING_RATE_AUTO
+	s.packetSize = 0
+	s.pmtu.Init(reliableUdp.pmtuDiscovery, reliableUdp.maxDatagramSize)
+	s.timeoutCount = 0
+	s.deliveryMode = reliableUdp.deliveryMode
+	s.unreliableSeq = 0
+	s.peerUnreliableSeq = 0
+	s.peerUnreliable = false
 	s.reliableUdp = reliableUdp
 	s.sendSeq = 0
+	s.seqSpace = NewSeqSpace(reliableUdp.seqBits)
 	s.sendBuf.Init(s)
-	s.recvBuf.Init(s)
+	s.recvBuf.Init(s, reliableUdp.recvWindow)
 	s.udpSocket = udpSocket
-	s.dstAddr = net.UDPAddr{IP: net.ParseIP(dIp), Port: dPort}
+	s.dstAddr = &net.UDPAddr{IP: net.ParseIP(dIp), Port: dPort}
+	s.pathAddr = nil
+	s.pathChallenge = 0
+	s.pathTs = 0
 	s.lossRate = 0
 	s.retransmissionRate = 0
 	s.statSendCount = 0
 	s.statAckCount = 0
+	s.closing = false
+	s.closed = false
+	s.pingInterval = reliableUdp.pingInterval
+	s.idleTimeout = reliableUdp.idleTimeout
+	s.lastRecvTs = time.Now().UnixNano()
+	s.lastPingTs = 0
+	s.pingSeq = 0
+	s.established = false
+	s.handshakeState = nil
+	s.ackPending = 0
+	s.ackDeadline = 0
+	s.ackSeq = 0
 }
 
-func (s *UdpSession) Close() {
+// SetSeqBits sets the width of the sequence numbers, agreed with the peer
+// during registration.
+func (s *UdpSession) SetSeqBits(bits int) {
+	s.seqSpace = NewSeqSpace(bits)
+}
+
+// SetPeerRing records the receive ring size the peer announced at
+// registration, the most fragments a message to it may have.
+func (s *UdpSession) SetPeerRing(size int) {
+	if size > 0 {
+		s.peerRing = size
+		s.peerWnd = size
+	}
+}
+
+func (s *UdpSession) GetPeerRing() int {
+	return s.peerRing
+}
+
+func (s *UdpSession) GetSeqSpace() SeqSpace {
+	return s.seqSpace
+}
+
+func (s *UdpSession) SetEstablished() {
+	s.established = true
+	s.handshakeState = nil
+	s.reliableUdp.timer.Cancel(s.registerTimer)
+}
+
+// SetRegisterDeadline gives up the registration at deadline unless the peer
+// answered it by then.
+func (s *UdpSession) SetRegisterDeadline(deadline int64) {
+	s.registerDeadline = deadline
+	s.registerTimer = s.reliableUdp.timer.Schedule(s.registerTimer, deadline, s, 0, nil)
+}
+
+func (s *UdpSession) IsRegisterExpired(curTs int64) bool {
+	return !s.established && !s.closed && curTs >= s.registerDeadline
+}
+
+func (s *UdpSession) IsEstablished() bool {
+	return s.established
+}
+
+func (s *UdpSession) GetHandshakeState() *HandshakeState {
+	return s.handshakeState
+}
+
+func (s *UdpSession) Close(code int) error {
+
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	s.closing = true
+	s.closed = true
+	s.closeCode = code
+	s.closeDeadline = time.Now().UnixNano() + s.reliableUdp.closeTimeout
+	s.closeTimer = s.reliableUdp.timer.Schedule(s.closeTimer, s.closeDeadline, s, 0, nil)
+	s.release()
+
+	fclog.DEBUG("Session close sid=%d code=%d", s.sessionId, code)
+
+	return s.SendClose(code)
+}
+
+func (s *UdpSession) OnPeerClose() {
+
+	s.closing = true
+	s.closed = true
+	s.release()
+}
+
+func (s *UdpSession) release() {
+	s.sendBuf.CancelTimers()
+	s.sendBuf.Init(s)
+	s.recvBuf.Init(s, 0)
+	s.backlog = make([]*BacklogItem, 0)
+	s.backlogCount = 0
+	s.streams = make(map[int32]*Stream, 0)
+}
+
+func (s *UdpSession) SetClosing() {
+	s.closing = true
+}
+
+func (s *UdpSession) IsClosing() bool {
+	return s.closing
+}
+
+func (s *UdpSession) IsClosed() bool {
+	return s.closed
+}
+
+func (s *UdpSession) IsCloseExpired(curTs int64) bool {
+	return s.closed && curTs >= s.closeDeadline
+}
+
+func (s *UdpSession) GetCloseCode() int {
+	return s.closeCode
+}
+
+func (s *UdpSession) GetPendingCount() int {
+	return s.sendBuf.GetLength() + s.backlogCount
+}
+
+func (s *UdpSession) SetWindowPolicy(policy int, backlog int) {
+	s.windowPolicy = policy
+	s.backlogLimit = backlog
+	fclog.DEBUG("SetWindowPolicy policy=%d backlog=%d", policy, backlog)
+}
+
+func (s *UdpSession) GetWindowPolicy() int {
+	return s.windowPolicy
+}
+
+// GetSendWindow returns how many packets may be in flight, the smaller of
+// the window the peer advertised and the congestion window, bounded by half
+// the sequence space.
+func (s *UdpSession) GetSendWindow() int {
+	wnd := s.peerWnd
+	if cwnd := s.congestion.GetWindow(); cwnd < wnd {
+		wnd = cwnd
+	}
+	if wnd > SEND_WINDOW_MAX {
+		return SEND_WINDOW_MAX
+	}
+	return wnd
+}
+
+func (s *UdpSession) SetCongestionController(congestion CongestionController) {
+	s.congestion = congestion
+}
+
+// SetPacingRate paces the data sent on the session at bitrate bits per
+// second. PACING_RATE_AUTO paces at the congestion window per smoothed round
+// trip time, PACING_RATE_OFF sends without pacing.
+func (s *UdpSession) SetPacingRate(bitrate int64) {
+	s.pacingRate = bitrate
+	s.UpdatePacing()
+	fclog.DEBUG("SetPacingRate bitrate=%d", bitrate)
+}
+
+func (s *UdpSession) UpdatePacing() {
+	burst := PACING_BURST_PACKETS * s.packetSize
+
+	if s.pacingRate > 0 {
+		s.pacer.SetRate(s.pacingRate/8, burst)
+		return
+	}
+
+	if s.pacingRate < 0 || s.srtt == 0 || s.packetSize == 0 {
+		s.pacer.SetRate(0, burst)
+		return
+	}
+
+	rate := float64(s.congestion.GetWindow()*s.packetSize) * 1e9 / float64(s.srtt) * PACING_GAIN
+	s.pacer.SetRate(int64(rate), burst)
+}
+
+func (s *UdpSession) GetCongestionWindow() int {
+	return s.congestion.GetWindow()
+}
+
+// CanSend reports whether a packet may be sent now. With nothing in flight
+// one packet is always allowed, so a closed window is probed until an ack
+// reopens it.
+func (s *UdpSession) CanSend() bool {
+	return s.backlogCount == 0 && s.isWindowOpen()
+}
+
+// isWindowOpen keeps the window closed until the registration completes,
+// data sent before would be sealed with the initial key the peer rejects.
+func (s *UdpSession) isWindowOpen() bool {
+	if !s.established {
+		return false
+	}
+	inFlight := s.sendBuf.GetLength()
+	return inFlight == 0 || inFlight < s.GetSendWindow()
+}
+
+func (s *UdpSession) CanQueue(count int) bool {
+	return s.backlogCount+count <= s.backlogLimit
+}
+
+func (s *UdpSession) SetDeliveryMode(mode int) {
+	s.deliveryMode = mode
+}
+
+func (s *UdpSession) GetDeliveryMode() int {
+	return s.deliveryMode
+}
+
+// Send sends b as far as the window allows and queues the rest. With
+// fragSize > 0 b is sent as a message split into fragments of at most
+// fragSize bytes, which the peer delivers whole, in order. Otherwise mode
+// tells whether the peer may deliver b ahead of earlier data. A non-nil
+// stream sends b on that stream instead of the session.
+func (s *UdpSession) Send(b []byte, fragSize int, mode int, stream *Stream) error {
+
+	if fragSize <= 0 {
+		return s.sendOrQueue(&BacklogItem{data: b, mode: int32(mode)}, stream)
+	}
+
+	total := fragmentCount(len(b), fragSize)
+	for i := 0; i < total; i++ {
+		start := i * fragSize
+		end := start + fragSize
+		if end > len(b) {
+			end = len(b)
+		}
+		item := &BacklogItem{data: b[start:end], frag: int32(i), total: int32(total)}
+		err := s.sendOrQueue(item, stream)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *UdpSession) sendOrQueue(item *BacklogItem, stream *Stream) error {
+	if stream != nil {
+		item.stream = stream.id
+		item.ssn = stream.sendSeq
+		stream.sendSeq = s.seqSpace.Next(stream.sendSeq)
+	}
+
+	if s.CanSend() {
+		return s.sendItem(item)
+	}
+
+	item.data = append([]byte{}, item.data...)
+	if stream != nil {
+		stream.backlog = append(stream.backlog, item)
+	} else {
+		s.backlog = append(s.backlog, item)
+	}
+	s.backlogCount += 1
+
+	return nil
+}
+
+// FlushBacklog sends queued data as far as the window allows, the streams
+// with the highest priority first. Data that fails to encode is dropped, the
+// first error is returned.
+func (s *UdpSession) FlushBacklog() error {
+	var firstErr error = nil
+
+	for s.backlogCount > 0 && s.isWindowOpen() {
+		err := s.sendItem(s.popBacklog())
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// popBacklog takes the first item queued by the highest priority stream,
+// the session itself counting as a stream of STREAM_PRIORITY_DEFAULT. Ties
+// go to the lowest stream id.
+func (s *UdpSession) popBacklog() *BacklogItem {
+
+	// A message partly sent is finished first, the peer needs its fragments
+	// in consecutive sequences.
+	queue := s.partialBacklog()
+
+	if queue == nil {
+		queue = &s.backlog
+		priority := STREAM_PRIORITY_DEFAULT
+		var id int32 = STREAM_DEFAULT
+		found := len(s.backlog) > 0
+
+		for _, stream := range s.streams {
+			if len(stream.backlog) == 0 {
+				continue
+			}
+			if !found || stream.priority > priority || (stream.priority == priority && stream.id < id) {
+				queue = &stream.backlog
+				priority = stream.priority
+				id = stream.id
+				found = true
+			}
+		}
+	}
+
+	item := (*queue)[0]
+	(*queue)[0] = nil
+	*queue = (*queue)[1:]
+	s.backlogCount -= 1
+
+	return item
+}
+
+// partialBacklog returns the queue, of the session or of a stream, holding
+// the rest of a message whose first fragments were sent, nil if none.
+func (s *UdpSession) partialBacklog() *[]*BacklogItem {
+	if len(s.backlog) > 0 && s.backlog[0].frag > 0 {
+		return &s.backlog
+	}
+
+	for _, stream := range s.streams {
+		if len(stream.backlog) > 0 && stream.backlog[0].frag > 0 {
+			return &stream.backlog
+		}
+	}
+
+	return nil
+}
+
+// OpenStream opens stream id, or changes its priority when it is open.
+func (s *UdpSession) OpenStream(id int32, priority int) error {
+
+	if id <= STREAM_DEFAULT {
+		return ErrUnknownStream
+	}
+
+	stream, exist := s.streams[id]
+	if exist {
+		if stream.closing {
+			return ErrStreamClosed
+		}
+		stream.SetPriority(priority)
+		return nil
+	}
+
+	if len(s.streams) >= s.maxStreams {
+		return ErrTooManyStreams
+	}
+
+	s.newStream(id, priority)
+
+	return nil
+}
+
+func (s *UdpSession) newStream(id int32, priority int) *Stream {
+	stream := new(Stream)
+	stream.Init(id, priority, s, s.reliableUdp.recvWindow)
+	s.streams[id] = stream
+	return stream
+}
 
+func (s *UdpSession) GetStream(id int32) *Stream {
+	return s.streams[id]
+}
+
+// CloseStream sends a fin on the stream after the data queued, nothing more
+// can be sent on it.
+func (s *UdpSession) CloseStream(id int32) error {
+
+	stream, exist := s.streams[id]
+	if !exist {
+		return ErrUnknownStream
+	}
+
+	if stream.closing {
+		return ErrStreamClosed
+	}
+
+	return s.closeStream(stream)
+}
+
+func (s *UdpSession) closeStream(stream *Stream) error {
+	stream.closing = true
+	return s.sendOrQueue(&BacklogItem{data: []byte{}, fin: true}, stream)
+}
+
+// checkStream removes the stream once both fins were exchanged.
+func (s *UdpSession) checkStream(stream *Stream) {
+	if stream.IsDone() {
+		fclog.DEBUG("Stream closed sid=%d stream=%d", s.sessionId, stream.id)
+		delete(s.streams, stream.id)
+	}
+}
+
+func fragmentCount(size int, fragSize int) int {
+	if size <= fragSize {
+		return 1
+	}
+	return (size + fragSize - 1) / fragSize
+}
+
+// GetFragmentSize returns the payload carried by one fragment, what fits in
+// the confirmed path MTU.
+func (s *UdpSession) GetFragmentSize() int {
+	return s.pmtu.GetPlpmtu() - DATAGRAM_OVERHEAD
+}
+
+// GetMaxPayload returns the largest payload of an unfragmented packet. Like
+// a fragment it must fit in the confirmed path MTU, not just in the endpoint
+// maximum.
+func (s *UdpSession) GetMaxPayload() int {
+	return s.GetFragmentSize()
+}
+
+func (s *UdpSession) GetPmtu() int {
+	return s.pmtu.GetPlpmtu()
+}
+
+func (s *UdpSession) GetMaxMessageSize() int {
+	return s.reliableUdp.maxMessageSize
+}
+
+// GetRecvWindow returns the window advertised to the peer, the room left in
+// the receive buffer.
+func (s *UdpSession) GetRecvWindow() int64 {
+	wnd := s.reliableUdp.recvWindow - s.recvBuf.GetLength()
+	for _, stream := range s.streams {
+		wnd -= stream.recvBuf.GetLength()
+	}
+	if wnd < 0 {
+		wnd = 0
+	}
+	return int64(wnd)
 }
 
 func (s *UdpSession) OnUdpRecv(b []byte, bLen int, ip string, port int) {
@@ -56,16 +553,124 @@ func (s *UdpSession) OnUdpRecv(b []byte, bLen int, ip string, port int) {
 }
 
 func (s *UdpSession) SendAckData(b []byte) {
-	s.udpSocket.SendCriticalData(b, &s.dstAddr)
+	s.udpSocket.SendCriticalData(b, s.dstAddr)
 }
 
 func (s *UdpSession) GetSid() int64 {
 	return s.sessionId
 }
 
-func (s *UdpSession) OnAck(seq int64) {
-	s.sendBuf.Delete(seq)
-	s.statAckCount += 1
+func (s *UdpSession) OnAck(msg *rudpmsg.RudpMsgAck) bool {
+	pending := s.sendBuf.GetLength()
+	curTs := time.Now().UnixNano()
+
+	rtt, sample := s.sendBuf.RttSample(msg.GetSeq(), curTs)
+	if sample {
+		s.UpdateRtt(rtt)
+	}
+
+	acked := s.sendBuf.Delete(msg.GetSeq())
+	lost := 0
+	if msg.Cum != nil {
+		acked += s.sendBuf.AckRange(msg.GetCum(), msg.GetSack())
+		lost = s.sendBuf.FastRetrans(msg.GetCum(), msg.GetSack(), s.fastThreshold, curTs)
+	}
+
+	if acked > 0 {
+		s.timeoutCount = 0
+		s.congestion.OnAck(acked, rtt, curTs)
+	}
+	if lost > 0 {
+		s.congestion.OnLoss(curTs)
+	}
+	s.UpdatePacing()
+	if msg.Wnd != nil {
+		s.peerWnd = int(msg.GetWnd())
+	}
+	s.statAckCount += int64(acked)
+
+	s.FlushBacklog()
+
+	return pending > 0 && s.GetPendingCount() == 0
+}
+
+func (s *UdpSession) IsPeerAddr(ip string, port int) bool {
+	return s.dPort == port && s.dstAddr.IP.Equal(net.ParseIP(ip))
+}
+
+func (s *UdpSession) GetPeerAddr() net.UDPAddr {
+	return *s.dstAddr
+}
+
+// SetPeerAddr replaces the peer address. The address is never changed in
+// place, datagrams already queued keep the one they were sent to.
+func (s *UdpSession) SetPeerAddr(ip string, port int) {
+	s.dIp = ip
+	s.dPort = port
+	s.dstAddr = &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+// ValidatePath challenges ip:port, a new address the peer sent from. The
+// session keeps its address until the challenge is answered from there, so
+// packets spoofed from another address can't redirect it. A challenge is
+// sent at most once per retransmission timeout.
+func (s *UdpSession) ValidatePath(ip string, port int, curTs int64) error {
+
+	if curTs-s.pathTs < s.rto {
+		return nil
+	}
+
+	challenge, err := newPathChallenge()
+	if err != nil {
+		fclog.ERROR("Generate path challenge error! err=%s", err.Error())
+		return err
+	}
+
+	s.pathAddr = &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+	s.pathChallenge = challenge
+	s.pathTs = curTs
+
+	var msg rudpmsg.RudpMsgPing
+	msg.Seq = proto.Int64(s.pingSeq)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Ts = proto.Int64(challenge)
+
+	s.pingSeq += 1
+
+	encryptData, err := s.encodeMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_PING)
+	if err != nil {
+		return err
+	}
+
+	s.udpSocket.SendCriticalData(encryptData, s.pathAddr)
+	return nil
+}
+
+// OnPathResponse switches to the challenged address once the pong of ts
+// came from there. It returns whether the address changed.
+func (s *UdpSession) OnPathResponse(ip string, port int, ts int64) bool {
+
+	if s.pathAddr == nil || ts != s.pathChallenge {
+		return false
+	}
+
+	if s.pathAddr.Port != port || !s.pathAddr.IP.Equal(net.ParseIP(ip)) {
+		return false
+	}
+
+	s.SetPeerAddr(ip, port)
+	s.pathAddr = nil
+
+	return true
+}
+
+func newPathChallenge() (int64, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b) >> 1), nil
 }
 
 func (s *UdpSession) SetMaxRetransmissionCount(count int) {
@@ -73,154 +678,667 @@ func (s *UdpSession) SetMaxRetransmissionCount(count int) {
 	fclog.DEBUG("SetMaxRetransmissionCount count=%d", count)
 }
 
-func (s *UdpSession) SetRetransmissionInterval(usecond int) {
-	s.retransInterval = int64(usecond * 1000000)
-	fclog.DEBUG("SetRetransmissionInterval interval=%d", usecond)
+// SetRetransmissionInterval sets the retransmission timeout used until the
+// first round trip time has been measured.
+func (s *UdpSession) SetRetransmissionInterval(msecond int) {
+	s.rto = s.BoundRto(int64(msecond) * 1000000)
+	fclog.DEBUG("SetRetransmissionInterval interval=%d", msecond)
 }
 
-func (s *UdpSession) SetReadTimeout(usecond int) {
-	s.readTimeout = int64(usecond * 1000000)
-	fclog.DEBUG("SetRetransmissionInterval interval=%d", usecond)
+// SetRtoBounds bounds the retransmission timeout, 0 < min <= max.
+func (s *UdpSession) SetRtoBounds(minMsecond int, maxMsecond int) error {
+	if minMsecond <= 0 || minMsecond > maxMsecond {
+		fclog.ERROR("SetRtoBounds error! min=%d max=%d", minMsecond, maxMsecond)
+		return ErrInvalidArgument
+	}
+	s.minRto = int64(minMsecond) * 1000000
+	s.maxRto = int64(maxMsecond) * 1000000
+	s.rto = s.BoundRto(s.rto)
+	fclog.DEBUG("SetRtoBounds min=%d max=%d", minMsecond, maxMsecond)
+
+	return nil
 }
 
-func (s *UdpSession) RetransmissionCheck() {
-	s.sendBuf.Check()
+// SetFastRetransThreshold sets how many later packets must be acked before a
+// missing one is retransmitted ahead of its timeout, 0 disables it.
+func (s *UdpSession) SetFastRetransThreshold(threshold int) {
+	s.fastThreshold = threshold
+	fclog.DEBUG("SetFastRetransThreshold threshold=%d", threshold)
 }
 
-func (s *UdpSession) SendData(b []byte) {
+// UpdateRtt folds a round trip time sample into the smoothed estimate and
+// recomputes the retransmission timeout as in RFC 6298.
+func (s *UdpSession) UpdateRtt(rtt int64) {
+	if s.srtt == 0 {
+		s.srtt = rtt
+		s.rttvar = rtt / 2
+	} else {
+		delta := s.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
 
-	var msg rudpmsg.RudpMsgData
-	msg.Seq = proto.Int64(s.sendSeq)
-	msg.Sid = proto.Int64(s.sessionId)
-	msg.Data = b
+	variance := 4 * s.rttvar
+	if variance < RTO_CLOCK_GRANULARITY {
+		variance = RTO_CLOCK_GRANULARITY
+	}
 
-	data, err := proto.Marshal(&msg)
-	if err != nil {
-		fclog.ERROR("Marshal message error!")
+	s.rto = s.BoundRto(s.srtt + variance)
+	fclog.DEBUG("Rtt sample=%d srtt=%d rttvar=%d rto=%d", rtt, s.srtt, s.rttvar, s.rto)
+}
+
+// OnRetransTimeout doubles the timeout and tells the congestion controller
+// after a retransmission timeout, at most once per timeout period however
+// many packets expired together.
+func (s *UdpSession) OnRetransTimeout(curTs int64) {
+	if curTs-s.backoffTs < s.rto {
 		return
 	}
+	s.backoffTs = curTs
+	s.rto = s.BoundRto(s.rto * 2)
+	s.congestion.OnTimeout(curTs)
+	s.UpdatePacing()
+
+	s.timeoutCount += 1
+	if s.timeoutCount >= PMTU_BLACKHOLE_TIMEOUTS && s.pmtu.GetPlpmtu() > DATAGRAM_SIZE_BASE {
+		fclog.INFO("Path MTU black hole, fall back sid=%d pmtu=%d", s.sessionId, s.pmtu.GetPlpmtu())
+		s.pmtu.Reset()
+	}
+}
 
-	packetData := rudpmsg.EncodePacket(data, rudpmsg.RudpMsgType_MSG_RUDP_DATA)
+func (s *UdpSession) BoundRto(rto int64) int64 {
+	if rto < s.minRto {
+		return s.minRto
+	}
+	if rto > s.maxRto {
+		return s.maxRto
+	}
+	return rto
+}
 
-	if len(packetData) <= 0 {
-		fclog.ERROR("EncodePacket error!")
+func (s *UdpSession) GetRto() int64 {
+	return s.rto
+}
+
+func (s *UdpSession) GetSrtt() int64 {
+	return s.srtt
+}
+
+func (s *UdpSession) SetKeepalive(intervalMsecond int, timeoutMsecond int) {
+	s.pingInterval = int64(intervalMsecond) * 1000000
+	s.idleTimeout = int64(timeoutMsecond) * 1000000
+	fclog.DEBUG("SetKeepalive interval=%d timeout=%d", intervalMsecond, timeoutMsecond)
+}
+
+func (s *UdpSession) UpdateRecvTs() {
+	s.lastRecvTs = time.Now().UnixNano()
+}
+
+func (s *UdpSession) IsIdleTimeout(curTs int64) bool {
+	return s.idleTimeout > 0 && curTs-s.lastRecvTs >= s.idleTimeout
+}
+
+func (s *UdpSession) KeepaliveCheck(curTs int64) {
+
+	if s.pingInterval <= 0 {
 		return
 	}
 
-	encryptData := s.reliableUdp.GetEncrypt().EncodePacket(packetData)
+	if curTs-s.lastRecvTs < s.pingInterval || curTs-s.lastPingTs < s.pingInterval {
+		return
+	}
 
-	s.sendBuf.Insert(encryptData, s.sendSeq)
-	s.sendSeq = (s.sendSeq + 1) % SEQ_MAX_INDEX
+	s.lastPingTs = curTs
+	s.SendPing(curTs)
+}
+
+// PmtuCheck sends the next path MTU probe when one is due.
+func (s *UdpSession) PmtuCheck(curTs int64) {
+
+	size, probe := s.pmtu.NextProbe(curTs, s.rto)
+	if !probe {
+		return
+	}
+
+	s.SendProbe(s.pmtu.GetProbeSeq(), size)
+}
+
+func (s *UdpSession) OnProbeAck(seq int64, size int) {
+	if s.pmtu.OnProbeAck(seq, size) {
+		fclog.INFO("Path MTU raised sid=%d pmtu=%d", s.sessionId, size)
+	}
+}
+
+func (s *UdpSession) ScheduleRetrans(seq int64, item *SendBuffItem) {
+	item.timer = s.reliableUdp.timer.Schedule(item.timer, item.ts+item.rto, s, seq, item)
+}
+
+func (s *UdpSession) CancelRetrans(item *SendBuffItem) {
+	s.reliableUdp.timer.Cancel(item.timer)
+}
+
+// CancelTimers removes every timer entry of the session once it is dropped.
+func (s *UdpSession) CancelTimers() {
+	s.sendBuf.CancelTimers()
+	s.reliableUdp.timer.Cancel(s.closeTimer)
+	s.reliableUdp.timer.Cancel(s.registerTimer)
+}
+
+func (s *UdpSession) OnRetransTimer(seq int64, item *SendBuffItem, deadline int64, curTs int64) {
+	if s.sendBuf.Expire(seq, item, deadline, curTs) {
+		s.FlushBacklog()
+	}
+}
+
+func (s *UdpSession) SendData(b []byte, frag int32, total int32, mode int32) error {
+	return s.sendItem(&BacklogItem{data: b, frag: frag, total: total, mode: mode})
+}
+
+func (s *UdpSession) sendItem(item *BacklogItem) error {
+
+	var msg rudpmsg.RudpMsgData
+	msg.Seq = proto.Int64(s.sendSeq)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Data = item.data
+	if item.total > 0 {
+		msg.Frag = proto.Int32(item.frag)
+		msg.Total = proto.Int32(item.total)
+	}
+	if item.mode != DELIVERY_RELIABLE_ORDERED {
+		msg.Mode = proto.Int32(item.mode)
+	}
+	if item.stream != STREAM_DEFAULT {
+		msg.Stream = proto.Int32(item.stream)
+		msg.Ssn = proto.Int64(item.ssn)
+	}
+	if item.fin {
+		msg.Fin = proto.Bool(true)
+	}
+
+	packetData, err := s.packMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_DATA)
+	if err != nil {
+		return err
+	}
+
+	encryptData, err := s.sealPacket(packetData)
+	if err != nil {
+		return err
+	}
+
+	s.sendBuf.Insert(packetData, s.sendSeq, false)
+	s.sendSeq = s.seqSpace.Next(s.sendSeq)
 	fclog.DEBUG("SendData seq++")
 
-	s.udpSocket.SendData(encryptData, &s.dstAddr)
+	if s.packetSize == 0 {
+		s.packetSize = len(encryptData)
+	} else {
+		s.packetSize = (7*s.packetSize + len(encryptData)) / 8
+	}
+
+	s.udpSocket.SendPacedData(encryptData, s.dstAddr, &s.pacer)
 
 	s.statSendCount += 1
+
+	if item.fin {
+		stream, exist := s.streams[item.stream]
+		if exist {
+			stream.finSent = true
+			s.checkStream(stream)
+		}
+	}
+
+	return nil
 }
 
-func (s *UdpSession) SendAck(seq int64) {
+// SendUnreliable sends b once, outside the sequence of reliable data and
+// without waiting for the window. Sequenced datagrams carry their own
+// sequence so the peer can drop stale ones.
+func (s *UdpSession) SendUnreliable(b []byte, mode int32) error {
+
+	if !s.established {
+		return ErrNotEstablished
+	}
+
+	var msg rudpmsg.RudpMsgData
+	msg.Seq = proto.Int64(0)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Data = b
+	msg.Mode = proto.Int32(mode)
+
+	if mode == DELIVERY_UNRELIABLE_SEQUENCED {
+		msg.Seq = proto.Int64(s.unreliableSeq)
+		s.unreliableSeq = s.seqSpace.Next(s.unreliableSeq)
+	}
+
+	encryptData, err := s.encodeMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_DATA)
+	if err != nil {
+		return err
+	}
+
+	s.udpSocket.SendPacedData(encryptData, s.dstAddr, &s.pacer)
+
+	return nil
+}
+
+func (s *UdpSession) SendAck(seq int64) error {
 
 	var msg rudpmsg.RudpMsgAck
 	msg.Seq = proto.Int64(seq)
 	msg.Sid = proto.Int64(s.sessionId)
 
-	data, err := proto.Marshal(&msg)
+	encryptData, err := s.encodeMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_ACK)
 	if err != nil {
-		fclog.ERROR("Marshal message error!")
-		return
+		return err
+	}
+
+	s.SendAckData(encryptData)
+	return nil
+}
+
+// OnDataAck records a received data packet for acknowledgement. Acks are
+// delayed to cover several packets, except for duplicates and out of order
+// arrivals which are acked at once so the sender learns about the gap.
+func (s *UdpSession) OnDataAck(seq int64, insertOK bool) {
+
+	// The peer drops the packet named in the ack, so a packet we couldn't
+	// take is only named when we had it already.
+	if insertOK || s.recvBuf.IsReceived(seq) {
+		s.ackSeq = seq
 	}
+	s.ackPending += 1
 
-	packetData := rudpmsg.EncodePacket(data, rudpmsg.RudpMsgType_MSG_RUDP_ACK)
+	cum, _ := s.recvBuf.GetAckState()
+	inOrder := s.seqSpace.Next(seq) == cum
 
-	if len(packetData) <= 0 {
-		fclog.ERROR("EncodePacket error!")
+	if !insertOK || !inOrder || s.ackPending >= ACK_EVERY_PACKETS {
+		s.SendSack()
 		return
 	}
 
-	encryptData := s.reliableUdp.GetEncrypt().EncodePacket(packetData)
+	if s.ackDeadline == 0 {
+		s.ackDeadline = time.Now().UnixNano() + s.reliableUdp.ackDelay
+	}
+}
+
+func (s *UdpSession) AckCheck(curTs int64) {
+	if s.ackPending > 0 && curTs >= s.ackDeadline {
+		s.SendSack()
+	}
+}
+
+func (s *UdpSession) SendSack() error {
+
+	cum, sack := s.recvBuf.GetAckState()
+
+	s.ackPending = 0
+	s.ackDeadline = 0
+
+	var msg rudpmsg.RudpMsgAck
+	msg.Seq = proto.Int64(s.ackSeq)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Cum = proto.Int64(cum)
+	msg.Sack = proto.Uint64(sack)
+	msg.Wnd = proto.Int64(s.GetRecvWindow())
+
+	encryptData, err := s.encodeMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_ACK)
+	if err != nil {
+		return err
+	}
+
 	s.SendAckData(encryptData)
+	return nil
 }
 
 func (s *UdpSession) SendRegister(sessionId int64) error {
 
 	var msg rudpmsg.RudpMsgReg
-	msg.Seq = proto.Int64(s.sendSeq)
+	msg.Seq = proto.Int64(SEQ_CONTROL)
 	msg.Sid = proto.Int64(sessionId)
+	msg.Seqbits = proto.Int32(int32(s.seqSpace.GetBits()))
+	msg.Wnd = proto.Int32(int32(len(s.recvBuf.items)))
 
-	data, err := proto.Marshal(&msg)
+	handshakeState, err := s.reliableUdp.handshake.InitRegister(sessionId, &msg)
 	if err != nil {
-		fclog.ERROR("Marshal message error!")
+		fclog.ERROR("Init register handshake error! err=%s", err.Error())
 		return err
 	}
+	s.handshakeState = handshakeState
 
-	packetData := rudpmsg.EncodePacket(data, rudpmsg.RudpMsgType_MSG_RUDP_REG)
+	encryptData, err := s.encodeMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_REG)
+	if err != nil {
+		return err
+	}
 
-	if len(packetData) <= 0 {
-		fclog.ERROR("EncodePacket error!")
+	s.sendBuf.Insert(encryptData, SEQ_CONTROL, true)
+
+	s.udpSocket.SendData(encryptData, s.dstAddr)
+
+	return nil
+}
+
+func (s *UdpSession) SendRegisterRs(msg *rudpmsg.RudpMsgRegRs) error {
+
+	msg.Seq = proto.Int64(SEQ_CONTROL)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Code = proto.Int64(REG_RS_CODE_OK)
+	msg.Seqbits = proto.Int32(int32(s.seqSpace.GetBits()))
+	msg.Wnd = proto.Int32(int32(len(s.recvBuf.items)))
+
+	// The peer opens the response with the key used before the handshake,
+	// resends must not be sealed with the session key installed after it.
+	encryptData, err := s.encodeMsg(msg, rudpmsg.RudpMsgType_MSG_RUDP_REG_RS)
+	if err != nil {
 		return err
 	}
 
-	encryptData := s.reliableUdp.GetEncrypt().EncodePacket(packetData)
+	s.sendBuf.Insert(encryptData, SEQ_CONTROL, true)
+
+	s.udpSocket.SendData(encryptData, s.dstAddr)
+
+	return nil
+}
 
-	s.sendBuf.Insert(encryptData, s.sendSeq)
+func (s *UdpSession) SendClose(code int) error {
+
+	var msg rudpmsg.RudpMsgClose
+	msg.Seq = proto.Int64(SEQ_CONTROL)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Code = proto.Int64(int64(code))
 
-	s.udpSocket.SendData(encryptData, &s.dstAddr)
+	packetData, err := s.packMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_CLOSE)
+	if err != nil {
+		return err
+	}
+
+	encryptData, err := s.sealPacket(packetData)
+	if err != nil {
+		return err
+	}
+
+	s.sendBuf.Insert(packetData, SEQ_CONTROL, false)
+
+	s.udpSocket.SendCriticalData(encryptData, s.dstAddr)
 
 	return nil
 }
 
-func (s *UdpSession) SendRegisterRs() bool {
+func (s *UdpSession) SendPing(ts int64) error {
 
-	var msg rudpmsg.RudpMsgRegRs
-	msg.Seq = proto.Int64(0)
+	var msg rudpmsg.RudpMsgPing
+	msg.Seq = proto.Int64(s.pingSeq)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Ts = proto.Int64(ts)
+
+	s.pingSeq += 1
+
+	return s.sendControl(&msg, rudpmsg.RudpMsgType_MSG_RUDP_PING)
+}
+
+func (s *UdpSession) SendPong(seq int64, ts int64) error {
+
+	var msg rudpmsg.RudpMsgPong
+	msg.Seq = proto.Int64(seq)
 	msg.Sid = proto.Int64(s.sessionId)
-	msg.Code = proto.Int64(0)
+	msg.Ts = proto.Int64(ts)
 
-	data, err := proto.Marshal(&msg)
+	return s.sendControl(&msg, rudpmsg.RudpMsgType_MSG_RUDP_PONG)
+}
+
+// SendProbe sends a probe padded to a datagram of exactly size bytes.
+func (s *UdpSession) SendProbe(seq int64, size int) error {
+
+	var msg rudpmsg.RudpMsgProbe
+	msg.Seq = proto.Int64(seq)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Size = proto.Int32(int32(size))
+	msg.Pad = []byte{}
+
+	// The length prefixes grow with the padding, adjust until it fits.
+	for i := 0; i < 4; i++ {
+		encryptData, err := s.encodeMsg(&msg, rudpmsg.RudpMsgType_MSG_RUDP_PROBE)
+		if err != nil {
+			return err
+		}
+
+		diff := size - len(encryptData)
+		if diff == 0 {
+			s.udpSocket.SendCriticalData(encryptData, s.dstAddr)
+			return nil
+		}
+
+		padLen := len(msg.Pad) + diff
+		if padLen < 0 {
+			fclog.ERROR("Probe size too small sid=%d size=%d", s.sessionId, size)
+			return ErrEncodeFailed
+		}
+		msg.Pad = make([]byte, padLen)
+	}
+
+	fclog.ERROR("Probe padding error sid=%d size=%d", s.sessionId, size)
+	return ErrEncodeFailed
+}
+
+func (s *UdpSession) SendProbeRs(seq int64, size int32) error {
+
+	var msg rudpmsg.RudpMsgProbeRs
+	msg.Seq = proto.Int64(seq)
+	msg.Sid = proto.Int64(s.sessionId)
+	msg.Size = proto.Int32(size)
+
+	return s.sendControl(&msg, rudpmsg.RudpMsgType_MSG_RUDP_PROBE_RS)
+}
+
+func (s *UdpSession) sendControl(msg proto.Message, msgType rudpmsg.RudpMsgType) error {
+
+	encryptData, err := s.encodeMsg(msg, msgType)
 	if err != nil {
-		fclog.ERROR("Marshal message error!")
-		return false
+		return err
+	}
+
+	s.udpSocket.SendCriticalData(encryptData, s.dstAddr)
+	return nil
+}
+
+func (s *UdpSession) encodeMsg(msg proto.Message, msgType rudpmsg.RudpMsgType) ([]byte, error) {
+
+	packetData, err := s.packMsg(msg, msgType)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sealPacket(packetData)
+}
+
+// packMsg encodes msg as a packet not sealed by the codec yet. Packets kept
+// for retransmission are sealed again on every send, so each copy gets a
+// fresh counter the replay window of the peer accepts.
+func (s *UdpSession) packMsg(msg proto.Message, msgType rudpmsg.RudpMsgType) ([]byte, error) {
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		fclog.ERROR("Marshal message error! sid=%d err=%s", s.sessionId, err.Error())
+		return nil, ErrEncodeFailed
 	}
 
-	packetData := rudpmsg.EncodePacket(data, rudpmsg.RudpMsgType_MSG_RUDP_REG_RS)
+	packetData := rudpmsg.EncodePacket(data, msgType)
 
 	if len(packetData) <= 0 {
-		fclog.ERROR("EncodePacket error!")
-		return false
+		fclog.ERROR("EncodePacket error! sid=%d", s.sessionId)
+		return nil, ErrEncodeFailed
 	}
 
-	encryptData := s.reliableUdp.GetEncrypt().EncodePacket(packetData)
+	return packetData, nil
+}
 
-	s.sendBuf.Insert(encryptData, s.sendSeq)
+func (s *UdpSession) sealPacket(packetData []byte) ([]byte, error) {
 
-	s.udpSocket.SendData(encryptData, &s.dstAddr)
+	encryptData := s.reliableUdp.encodePacket(s.sessionId, packetData)
+	if encryptData == nil {
+		fclog.ERROR("Encode packet error! sid=%d", s.sessionId)
+		return nil, ErrEncodeFailed
+	}
 
-	return true
+	return encryptData, nil
 }
 
 func (s *UdpSession) GetRetransCount() int {
 	return s.retransCount
 }
 
-func (s *UdpSession) GetRetransInterval() int64 {
-	return s.retransInterval
+// ResendControl sends the pending registration or close message again, as
+// when the peer repeats a registration whose response was lost.
+func (s *UdpSession) ResendControl() {
+	item, have := s.sendBuf.seqMap[SEQ_CONTROL]
+	if have {
+		s.SendRetransData(item)
+	}
 }
 
-func (s *UdpSession) SendRetransData(b []byte) {
-	s.udpSocket.SendCriticalData(b, &s.dstAddr)
+func (s *UdpSession) SendRetransData(item *SendBuffItem) {
+
+	encryptData := item.data
+	if !item.sealed {
+		var err error
+		encryptData, err = s.sealPacket(item.data)
+		if err != nil {
+			return
+		}
+	}
+
+	s.udpSocket.SendPacedData(encryptData, s.dstAddr, &s.pacer)
 }
 
-func (s *UdpSession) OnDataRecv(seq int64, b []byte) bool {
-	return s.recvBuf.Insert(seq, b)
+func (s *UdpSession) OnDataRecv(seq int64, b []byte, frag int32, total int32, mode int32) bool {
+	if mode == DELIVERY_RELIABLE_UNORDERED && total == 0 {
+		return s.recvBuf.InsertUnordered(seq, b)
+	}
+	return s.recvBuf.Insert(seq, b, frag, total)
 }
 
-func (s *UdpSession) ReadCheck() (b []byte, bRead bool) {
-	return s.recvBuf.GetData()
+// OnStreamRecv records a packet of a stream. Data the stream has no room
+// for is not recorded, so the peer sends it again.
+func (s *UdpSession) OnStreamRecv(seq int64, id int32, ssn int64, b []byte, frag int32, total int32, fin bool) bool {
+
+	if !s.recvBuf.accept(seq) {
+		return false
+	}
+
+	stream, exist := s.streams[id]
+	if !exist {
+		if id <= STREAM_DEFAULT || len(s.streams) >= s.maxStreams {
+			fclog.ERROR("Drop data of stream over limit sid=%d stream=%d", s.sessionId, id)
+			return false
+		}
+		stream = s.newStream(id, STREAM_PRIORITY_DEFAULT)
+	}
+
+	if !fin && !s.reserveStreamRing(stream, ssn) {
+		fclog.ERROR("Stream receive ring full sid=%d stream=%d ssn=%d", s.sessionId, id, ssn)
+		return false
+	}
+
+	s.recvBuf.InsertDelivered(seq)
+
+	if fin {
+		stream.peerFin = true
+		stream.finSeq = ssn
+		return true
+	}
+
+	if !stream.recvBuf.Insert(ssn, b, frag, total) {
+		fclog.ERROR("Drop stream data sid=%d stream=%d ssn=%d", s.sessionId, id, ssn)
+	}
+
+	return true
 }
 
-func (s *UdpSession) ReadTimeoutCheck() (b []byte, bRead bool) {
-	curTs := time.Now().UnixNano()
-	return s.recvBuf.GetTimeoutData(curTs, s.readTimeout*1000000)
+// reserveStreamRing grows the receive ring of stream to hold ssn. The rings
+// of all streams share the size of the session ring, so opening streams
+// doesn't let a peer make us hold more.
+func (s *UdpSession) reserveStreamRing(stream *Stream, ssn int64) bool {
+
+	if ssn < 0 || ssn >= int64(s.seqSpace) {
+		return false
+	}
+
+	ring := &stream.recvBuf
+	distance := s.seqSpace.Distance(ring.nextSeq, ssn)
+	if distance >= s.seqSpace.Half() || distance < int64(ring.GetSize()) {
+		return true
+	}
+
+	free := s.recvBuf.GetSize() + ring.GetSize()
+	for _, other := range s.streams {
+		free -= other.recvBuf.GetSize()
+	}
+
+	need := int(distance) + 1
+	size := 2 * ring.GetSize()
+	if size < STREAM_RING_MIN {
+		size = STREAM_RING_MIN
+	}
+	if size < need {
+		size = need
+	}
+	if size > free {
+		size = free
+	}
+	if size < need {
+		return false
+	}
+
+	return ring.Resize(size)
+}
+
+// ReadStreamCheck returns the next message delivered on any stream. fin is
+// set with no data once the peer closed the stream, which is then closed on
+// this side too.
+func (s *UdpSession) ReadStreamCheck() (id int32, b []byte, fin bool, bRead bool) {
+
+	for id, stream := range s.streams {
+		if stream.recvBuf.GetLength() == 0 && stream.recvBuf.GetSize() > 0 {
+			stream.recvBuf.Resize(0)
+		}
+
+		data, _, ok := stream.recvBuf.GetData()
+		if ok {
+			return id, data, false, true
+		}
+
+		if stream.IsPeerFinished() && !stream.finDelivered {
+			stream.finDelivered = true
+			if !stream.closing {
+				s.closeStream(stream)
+			}
+			s.checkStream(stream)
+			return id, nil, true, true
+		}
+	}
+
+	return 0, nil, false, false
+}
+
+// OnUnreliableRecv queues an unreliable datagram for delivery. Sequenced
+// ones older than the last delivered are dropped.
+func (s *UdpSession) OnUnreliableRecv(seq int64, b []byte, mode int32) bool {
+
+	if mode == DELIVERY_UNRELIABLE_SEQUENCED {
+		if s.peerUnreliable && !s.seqSpace.Less(s.peerUnreliableSeq, seq) {
+			fclog.DEBUG("Drop stale sequenced datagram sid=%d seq=%d", s.sessionId, seq)
+			return false
+		}
+		s.peerUnreliableSeq = seq
+		s.peerUnreliable = true
+	}
+
+	return s.recvBuf.Push(b)
+}
+
+func (s *UdpSession) ReadCheck() (b []byte, message bool, bRead bool) {
+	return s.recvBuf.GetData()
 }
 
 func (s *UdpSession) GetLossrate() int {
@@ -0,0 +1,137 @@
+package rudp
+
+import "testing"
+import "time"
+
+func newTestSession() *UdpSession {
+	s := new(UdpSession)
+	s.Init(1, "127.0.0.1", 1, nil, NewReliableUdp())
+	return s
+}
+
+func TestRtoEstimate(t *testing.T) {
+	const ms = int64(time.Millisecond)
+
+	tests := []struct {
+		samples []int64
+		srtt    int64
+		rttvar  int64
+		rto     int64
+	}{
+		// The first sample sets SRTT and half of it as RTTVAR.
+		{[]int64{100 * ms}, 100 * ms, 50 * ms, 300 * ms},
+		// RTTVAR = 3/4 RTTVAR + 1/4 |SRTT - R|, SRTT = 7/8 SRTT + 1/8 R.
+		{[]int64{100 * ms, 200 * ms}, 112500000, 62500000, 362500000},
+		// On a steady path RTTVAR decays, the clock granularity is used as
+		// variance and the result is raised to the lower bound.
+		{[]int64{ms, ms, ms}, ms, 281250, RTO_MIN_DEFAULT},
+		{[]int64{30 * 1000 * ms}, 30 * 1000 * ms, 15 * 1000 * ms, RTO_MAX_DEFAULT},
+	}
+
+	for i, test := range tests {
+		s := newTestSession()
+		for _, rtt := range test.samples {
+			s.UpdateRtt(rtt)
+		}
+		if s.srtt != test.srtt || s.rttvar != test.rttvar || s.GetRto() != test.rto {
+			t.Errorf("case %d: srtt=%d rttvar=%d rto=%d, want %d %d %d", i, s.srtt, s.rttvar, s.GetRto(), test.srtt, test.rttvar, test.rto)
+		}
+	}
+}
+
+func TestRetransmissionIntervalMilliseconds(t *testing.T) {
+	s := newTestSession()
+
+	s.SetRetransmissionInterval(300)
+	if s.GetRto() != 300*int64(time.Millisecond) {
+		t.Fatalf("rto=%d", s.GetRto())
+	}
+
+	s.SetRetransmissionInterval(1)
+	if s.GetRto() != RTO_MIN_DEFAULT {
+		t.Fatalf("rto below the lower bound rto=%d", s.GetRto())
+	}
+}
+
+func TestRttSampleKarn(t *testing.T) {
+	s := newTestSession()
+
+	s.sendBuf.Insert([]byte{0}, 0, false)
+	s.sendBuf.Insert([]byte{1}, 1, false)
+	s.sendBuf.seqMap[1].retrans = 1
+
+	curTs := s.sendBuf.seqMap[0].ts + int64(5*time.Millisecond)
+	if rtt, ok := s.sendBuf.RttSample(0, curTs); !ok || rtt != int64(5*time.Millisecond) {
+		t.Fatalf("sample rtt=%d ok=%v", rtt, ok)
+	}
+	if _, ok := s.sendBuf.RttSample(1, curTs); ok {
+		t.Fatal("retransmitted packet gave an rtt sample")
+	}
+}
+
+func TestRtoBackoff(t *testing.T) {
+	const ms = int64(time.Millisecond)
+
+	s := newTestSession()
+	s.SetRtoBounds(200, 2000)
+	s.SetRetransmissionInterval(300)
+
+	curTs := time.Now().UnixNano()
+	s.OnRetransTimeout(curTs)
+	if s.GetRto() != 600*ms {
+		t.Fatalf("rto=%d after one timeout", s.GetRto())
+	}
+
+	// Packets expiring together back off once.
+	s.OnRetransTimeout(curTs + ms)
+	if s.GetRto() != 600*ms {
+		t.Fatalf("rto=%d after a second timeout of the same period", s.GetRto())
+	}
+
+	curTs += 600 * ms
+	s.OnRetransTimeout(curTs)
+	if s.GetRto() != 1200*ms {
+		t.Fatalf("rto=%d after two timeouts", s.GetRto())
+	}
+
+	curTs += 1200 * ms
+	s.OnRetransTimeout(curTs)
+	if s.GetRto() != 2000*ms {
+		t.Fatalf("rto=%d above the upper bound", s.GetRto())
+	}
+}
+
+func TestRtoBoundsRejected(t *testing.T) {
+	s := newTestSession()
+
+	if err := s.SetRtoBounds(0, 0); err != ErrInvalidArgument {
+		t.Fatalf("zero bounds err=%v", err)
+	}
+	if err := s.SetRtoBounds(500, 100); err != ErrInvalidArgument {
+		t.Fatalf("min above max err=%v", err)
+	}
+	if s.minRto != RTO_MIN_DEFAULT || s.maxRto != RTO_MAX_DEFAULT {
+		t.Fatalf("rejected bounds applied min=%d max=%d", s.minRto, s.maxRto)
+	}
+}
+
+func TestControlSeqApartFromData(t *testing.T) {
+	s := newTestSession()
+
+	s.sendBuf.Insert([]byte("reg rs"), SEQ_CONTROL, true)
+	for seq := int64(0); seq < 4; seq++ {
+		s.sendBuf.Insert([]byte{byte(seq)}, seq, false)
+	}
+
+	// Acks of data, cumulative ones included, leave the control message.
+	s.sendBuf.Delete(0)
+	s.sendBuf.AckRange(4, 0)
+	if s.sendBuf.GetLength() != 1 || s.sendBuf.seqMap[SEQ_CONTROL] == nil {
+		t.Fatalf("send buffer holds %d packets", s.sendBuf.GetLength())
+	}
+
+	s.sendBuf.Delete(SEQ_CONTROL)
+	if s.sendBuf.GetLength() != 0 {
+		t.Fatal("control message not acked")
+	}
+}
@@ -0,0 +1,70 @@
+package rudp
+
+const (
+	STREAM_DEFAULT          = 0
+	STREAM_PRIORITY_DEFAULT = 0
+	STREAM_MAX_DEFAULT      = 64
+	STREAM_RING_MIN         = 16
+)
+
+// Stream is a logical channel of a session. Messages sent on a stream carry
+// a stream sequence and are delivered in that order, so a packet lost on one
+// stream doesn't hold back the others. Acks and retransmission stay with the
+// session. When the send window is full, queued data of streams with a
+// higher priority goes first.
+//
+// The receive ring of a stream grows with the data waiting on it and is
+// released once the stream is drained. The rings of a session share the size
+// of its receive window.
+//
+// Closing sends a fin after the data queued, the peer answers with its own
+// fin and each side removes the stream once both fins were exchanged.
+type Stream struct {
+	id           int32
+	priority     int
+	sendSeq      int64
+	backlog      []*BacklogItem
+	recvBuf      RecvBuff
+	closing      bool
+	finSent      bool
+	peerFin      bool
+	finSeq       int64
+	finDelivered bool
+}
+
+func (t *Stream) Init(id int32, priority int, udpSession *UdpSession, size int) {
+	t.id = id
+	t.priority = priority
+	t.sendSeq = 0
+	t.backlog = make([]*BacklogItem, 0)
+	t.recvBuf.InitLazy(udpSession, size)
+	t.closing = false
+	t.finSent = false
+	t.peerFin = false
+	t.finSeq = 0
+	t.finDelivered = false
+}
+
+func (t *Stream) GetId() int32 {
+	return t.id
+}
+
+func (t *Stream) SetPriority(priority int) {
+	t.priority = priority
+}
+
+func (t *Stream) GetPriority() int {
+	return t.priority
+}
+
+// IsPeerFinished reports whether every message of the peer was delivered
+// up to its fin.
+func (t *Stream) IsPeerFinished() bool {
+	return t.peerFin && t.recvBuf.nextSeq == t.finSeq && t.recvBuf.GetLength() == 0
+}
+
+// IsDone reports whether both fins were exchanged and the peer's was
+// reported.
+func (t *Stream) IsDone() bool {
+	return t.finSent && t.finDelivered
+}
@@ -0,0 +1,131 @@
+package rudp
+
+import "time"
+import "container/heap"
+
+const (
+	TIMER_IDLE_WAIT = 1000 * 1000000
+)
+
+type timerEntry struct {
+	deadline int64
+	session  *UdpSession
+	seq      int64
+	item     *SendBuffItem
+	index    int
+}
+
+type timerHeap []*timerEntry
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].deadline < h[j].deadline
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	entry := x.(*timerEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[0 : n-1]
+	entry.index = -1
+	return entry
+}
+
+// RetransTimer schedules packet retransmissions and session close deadlines
+// on a min-heap ordered by deadline. Every packet keeps one entry that is
+// moved when it is rescheduled and removed once the packet is acked or its
+// session dropped. It is guarded by the ReliableUdp lock.
+type RetransTimer struct {
+	entries  timerHeap
+	wakeChan chan bool
+}
+
+func (t *RetransTimer) Init() {
+	t.entries = make(timerHeap, 0, 100)
+	t.wakeChan = make(chan bool, 1)
+}
+
+// Schedule sets the deadline of entry and returns it, a nil entry is
+// created. A nil item schedules the close deadline of the session.
+func (t *RetransTimer) Schedule(entry *timerEntry, deadline int64, session *UdpSession, seq int64, item *SendBuffItem) *timerEntry {
+
+	if entry == nil {
+		entry = &timerEntry{index: -1}
+	}
+	entry.deadline = deadline
+	entry.session = session
+	entry.seq = seq
+	entry.item = item
+
+	if entry.index >= 0 {
+		heap.Fix(&t.entries, entry.index)
+	} else {
+		heap.Push(&t.entries, entry)
+	}
+
+	if t.entries[0] == entry {
+		select {
+		case t.wakeChan <- true:
+		default:
+		}
+	}
+
+	return entry
+}
+
+// Cancel removes entry unless it already expired.
+func (t *RetransTimer) Cancel(entry *timerEntry) {
+
+	if entry == nil || entry.index < 0 {
+		return
+	}
+
+	heap.Remove(&t.entries, entry.index)
+}
+
+func (t *RetransTimer) Len() int {
+	return len(t.entries)
+}
+
+// Expire pops every entry whose deadline is not after curTs.
+func (t *RetransTimer) Expire(curTs int64) []*timerEntry {
+
+	expired := make([]*timerEntry, 0)
+
+	for len(t.entries) > 0 && t.entries[0].deadline <= curTs {
+		expired = append(expired, heap.Pop(&t.entries).(*timerEntry))
+	}
+
+	return expired
+}
+
+// NextDelay returns how long to sleep until the earliest deadline.
+func (t *RetransTimer) NextDelay(curTs int64) time.Duration {
+
+	if len(t.entries) == 0 {
+		return TIMER_IDLE_WAIT
+	}
+
+	delay := t.entries[0].deadline - curTs
+	if delay < 0 {
+		delay = 0
+	}
+
+	return time.Duration(delay)
+}
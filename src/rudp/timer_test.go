@@ -0,0 +1,67 @@
+package rudp
+
+import "testing"
+
+func TestTimerExpiresInOrder(t *testing.T) {
+	var timer RetransTimer
+	timer.Init()
+
+	entries := make([]*timerEntry, 0)
+	for i := 0; i < 5; i++ {
+		entries = append(entries, timer.Schedule(nil, int64(10+i), nil, int64(i), nil))
+	}
+
+	// Moving an entry reuses it, cancelling takes it out.
+	timer.Schedule(entries[4], 1, nil, 4, nil)
+	timer.Cancel(entries[2])
+	if timer.Len() != 4 {
+		t.Fatalf("timer entries=%d", timer.Len())
+	}
+
+	expired := timer.Expire(12)
+	if len(expired) != 3 || expired[0].seq != 4 || expired[1].seq != 0 || expired[2].seq != 1 {
+		t.Fatalf("expired %v", expired)
+	}
+
+	// Expired entries are gone, cancelling them again does nothing.
+	timer.Cancel(expired[0])
+	if timer.Len() != 1 {
+		t.Fatalf("timer entries=%d", timer.Len())
+	}
+}
+
+func TestTimerRescheduleKeepsOneEntry(t *testing.T) {
+	s := newTestSession()
+	timer := &s.reliableUdp.timer
+
+	for seq := int64(0); seq < 10; seq++ {
+		s.sendBuf.Insert([]byte{byte(seq)}, seq, false)
+	}
+
+	for i := 0; i < 1000; i++ {
+		for seq, item := range s.sendBuf.seqMap {
+			item.ts += 1
+			s.ScheduleRetrans(seq, item)
+		}
+	}
+	if timer.Len() != 10 {
+		t.Fatalf("timer entries=%d after rescheduling", timer.Len())
+	}
+
+	s.sendBuf.Insert([]byte{0}, 0, false)
+	s.sendBuf.Delete(9)
+	if timer.Len() != 9 {
+		t.Fatalf("timer entries=%d after replace and ack", timer.Len())
+	}
+
+	s.sendBuf.AckRange(5, 0)
+	if timer.Len() != 4 {
+		t.Fatalf("timer entries=%d after cumulative ack", timer.Len())
+	}
+
+	s.reliableUdp.sessionMap[1] = s
+	s.reliableUdp.dropSession(1)
+	if timer.Len() != 0 {
+		t.Fatalf("timer entries=%d after the session was dropped", timer.Len())
+	}
+}
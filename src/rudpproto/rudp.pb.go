@@ -1,243 +1,1051 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
 // source: rudp.proto
 
-/*
-Package rudpmsg is a generated protocol buffer package.
-
-It is generated from these files:
-	rudp.proto
-
-It has these top-level messages:
-	RudpMessage
-	RudpMsgReg
-	RudpMsgRegRs
-	RudpMsgData
-	RudpMsgAck
-*/
 package rudpmsg
 
-import proto "github.com/golang/protobuf/proto"
-import fmt "fmt"
-import math "math"
-
-// Reference imports to suppress errors if they are not otherwise used.
-var _ = proto.Marshal
-var _ = fmt.Errorf
-var _ = math.Inf
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the proto package it is being compiled against.
-// A compilation error at this line likely means your copy of the
-// proto package needs to be updated.
-const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
 
 type RudpMsgType int32
 
 const (
-	RudpMsgType_MSG_RUDP_DATA   RudpMsgType = 1
-	RudpMsgType_MSG_RUDP_ACK    RudpMsgType = 2
-	RudpMsgType_MSG_RUDP_REG    RudpMsgType = 3
-	RudpMsgType_MSG_RUDP_REG_RS RudpMsgType = 4
+	RudpMsgType_MSG_RUDP_DATA     RudpMsgType = 1
+	RudpMsgType_MSG_RUDP_ACK      RudpMsgType = 2
+	RudpMsgType_MSG_RUDP_REG      RudpMsgType = 3
+	RudpMsgType_MSG_RUDP_REG_RS   RudpMsgType = 4
+	RudpMsgType_MSG_RUDP_CLOSE    RudpMsgType = 5
+	RudpMsgType_MSG_RUDP_CLOSE_RS RudpMsgType = 6
+	RudpMsgType_MSG_RUDP_PING     RudpMsgType = 7
+	RudpMsgType_MSG_RUDP_PONG     RudpMsgType = 8
+	RudpMsgType_MSG_RUDP_PROBE    RudpMsgType = 9
+	RudpMsgType_MSG_RUDP_PROBE_RS RudpMsgType = 10
 )
 
-var RudpMsgType_name = map[int32]string{
-	1: "MSG_RUDP_DATA",
-	2: "MSG_RUDP_ACK",
-	3: "MSG_RUDP_REG",
-	4: "MSG_RUDP_REG_RS",
-}
-var RudpMsgType_value = map[string]int32{
-	"MSG_RUDP_DATA":   1,
-	"MSG_RUDP_ACK":    2,
-	"MSG_RUDP_REG":    3,
-	"MSG_RUDP_REG_RS": 4,
-}
+// Enum value maps for RudpMsgType.
+var (
+	RudpMsgType_name = map[int32]string{
+		1:  "MSG_RUDP_DATA",
+		2:  "MSG_RUDP_ACK",
+		3:  "MSG_RUDP_REG",
+		4:  "MSG_RUDP_REG_RS",
+		5:  "MSG_RUDP_CLOSE",
+		6:  "MSG_RUDP_CLOSE_RS",
+		7:  "MSG_RUDP_PING",
+		8:  "MSG_RUDP_PONG",
+		9:  "MSG_RUDP_PROBE",
+		10: "MSG_RUDP_PROBE_RS",
+	}
+	RudpMsgType_value = map[string]int32{
+		"MSG_RUDP_DATA":     1,
+		"MSG_RUDP_ACK":      2,
+		"MSG_RUDP_REG":      3,
+		"MSG_RUDP_REG_RS":   4,
+		"MSG_RUDP_CLOSE":    5,
+		"MSG_RUDP_CLOSE_RS": 6,
+		"MSG_RUDP_PING":     7,
+		"MSG_RUDP_PONG":     8,
+		"MSG_RUDP_PROBE":    9,
+		"MSG_RUDP_PROBE_RS": 10,
+	}
+)
 
 func (x RudpMsgType) Enum() *RudpMsgType {
 	p := new(RudpMsgType)
 	*p = x
 	return p
 }
+
 func (x RudpMsgType) String() string {
-	return proto.EnumName(RudpMsgType_name, int32(x))
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
 }
-func (x *RudpMsgType) UnmarshalJSON(data []byte) error {
-	value, err := proto.UnmarshalJSONEnum(RudpMsgType_value, data, "RudpMsgType")
+
+func (RudpMsgType) Descriptor() protoreflect.EnumDescriptor {
+	return file_rudp_proto_enumTypes[0].Descriptor()
+}
+
+func (RudpMsgType) Type() protoreflect.EnumType {
+	return &file_rudp_proto_enumTypes[0]
+}
+
+func (x RudpMsgType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Do not use.
+func (x *RudpMsgType) UnmarshalJSON(b []byte) error {
+	num, err := protoimpl.X.UnmarshalJSONEnum(x.Descriptor(), b)
 	if err != nil {
 		return err
 	}
-	*x = RudpMsgType(value)
+	*x = RudpMsgType(num)
 	return nil
 }
-func (RudpMsgType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+// Deprecated: Use RudpMsgType.Descriptor instead.
+func (RudpMsgType) EnumDescriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{0}
+}
 
 type RudpMessage struct {
-	Type             *RudpMsgType `protobuf:"varint,1,req,name=type,enum=rudpmsg.RudpMsgType" json:"type,omitempty"`
-	Data             []byte       `protobuf:"bytes,2,req,name=data" json:"data,omitempty"`
-	XXX_unrecognized []byte       `json:"-"`
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          *RudpMsgType           `protobuf:"varint,1,req,name=type,enum=rudpmsg.RudpMsgType" json:"type,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,req,name=data" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMessage) Reset() {
+	*x = RudpMessage{}
+	mi := &file_rudp_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func (m *RudpMessage) Reset()                    { *m = RudpMessage{} }
-func (m *RudpMessage) String() string            { return proto.CompactTextString(m) }
-func (*RudpMessage) ProtoMessage()               {}
-func (*RudpMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+func (*RudpMessage) ProtoMessage() {}
 
-func (m *RudpMessage) GetType() RudpMsgType {
-	if m != nil && m.Type != nil {
-		return *m.Type
+func (x *RudpMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMessage.ProtoReflect.Descriptor instead.
+func (*RudpMessage) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RudpMessage) GetType() RudpMsgType {
+	if x != nil && x.Type != nil {
+		return *x.Type
 	}
 	return RudpMsgType_MSG_RUDP_DATA
 }
 
-func (m *RudpMessage) GetData() []byte {
-	if m != nil {
-		return m.Data
+func (x *RudpMessage) GetData() []byte {
+	if x != nil {
+		return x.Data
 	}
 	return nil
 }
 
 type RudpMsgReg struct {
-	Seq              *int64 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
-	Sid              *int64 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Pubkey        []byte                 `protobuf:"bytes,3,opt,name=pubkey" json:"pubkey,omitempty"`
+	Pskid         []byte                 `protobuf:"bytes,4,opt,name=pskid" json:"pskid,omitempty"`
+	Mac           []byte                 `protobuf:"bytes,5,opt,name=mac" json:"mac,omitempty"`
+	Seqbits       *int32                 `protobuf:"varint,6,opt,name=seqbits" json:"seqbits,omitempty"`
+	Nonce         []byte                 `protobuf:"bytes,7,opt,name=nonce" json:"nonce,omitempty"`
+	Wnd           *int32                 `protobuf:"varint,8,opt,name=wnd" json:"wnd,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgReg) Reset() {
+	*x = RudpMsgReg{}
+	mi := &file_rudp_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgReg) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func (m *RudpMsgReg) Reset()                    { *m = RudpMsgReg{} }
-func (m *RudpMsgReg) String() string            { return proto.CompactTextString(m) }
-func (*RudpMsgReg) ProtoMessage()               {}
-func (*RudpMsgReg) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*RudpMsgReg) ProtoMessage() {}
 
-func (m *RudpMsgReg) GetSeq() int64 {
-	if m != nil && m.Seq != nil {
-		return *m.Seq
+func (x *RudpMsgReg) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgReg.ProtoReflect.Descriptor instead.
+func (*RudpMsgReg) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RudpMsgReg) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
 	}
 	return 0
 }
 
-func (m *RudpMsgReg) GetSid() int64 {
-	if m != nil && m.Sid != nil {
-		return *m.Sid
+func (x *RudpMsgReg) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
+
+func (x *RudpMsgReg) GetPubkey() []byte {
+	if x != nil {
+		return x.Pubkey
+	}
+	return nil
+}
+
+func (x *RudpMsgReg) GetPskid() []byte {
+	if x != nil {
+		return x.Pskid
+	}
+	return nil
+}
+
+func (x *RudpMsgReg) GetMac() []byte {
+	if x != nil {
+		return x.Mac
+	}
+	return nil
+}
+
+func (x *RudpMsgReg) GetSeqbits() int32 {
+	if x != nil && x.Seqbits != nil {
+		return *x.Seqbits
+	}
+	return 0
+}
+
+func (x *RudpMsgReg) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *RudpMsgReg) GetWnd() int32 {
+	if x != nil && x.Wnd != nil {
+		return *x.Wnd
 	}
 	return 0
 }
 
 type RudpMsgRegRs struct {
-	Seq              *int64 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
-	Sid              *int64 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
-	Code             *int64 `protobuf:"varint,3,req,name=code" json:"code,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Code          *int64                 `protobuf:"varint,3,req,name=code" json:"code,omitempty"`
+	Pubkey        []byte                 `protobuf:"bytes,4,opt,name=pubkey" json:"pubkey,omitempty"`
+	Mac           []byte                 `protobuf:"bytes,5,opt,name=mac" json:"mac,omitempty"`
+	Seqbits       *int32                 `protobuf:"varint,6,opt,name=seqbits" json:"seqbits,omitempty"`
+	Nonce         []byte                 `protobuf:"bytes,7,opt,name=nonce" json:"nonce,omitempty"`
+	Wnd           *int32                 `protobuf:"varint,8,opt,name=wnd" json:"wnd,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgRegRs) Reset() {
+	*x = RudpMsgRegRs{}
+	mi := &file_rudp_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgRegRs) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgRegRs) ProtoMessage() {}
+
+func (x *RudpMsgRegRs) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgRegRs.ProtoReflect.Descriptor instead.
+func (*RudpMsgRegRs) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RudpMsgRegRs) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
 }
 
-func (m *RudpMsgRegRs) Reset()                    { *m = RudpMsgRegRs{} }
-func (m *RudpMsgRegRs) String() string            { return proto.CompactTextString(m) }
-func (*RudpMsgRegRs) ProtoMessage()               {}
-func (*RudpMsgRegRs) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (x *RudpMsgRegRs) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
 
-func (m *RudpMsgRegRs) GetSeq() int64 {
-	if m != nil && m.Seq != nil {
-		return *m.Seq
+func (x *RudpMsgRegRs) GetCode() int64 {
+	if x != nil && x.Code != nil {
+		return *x.Code
 	}
 	return 0
 }
 
-func (m *RudpMsgRegRs) GetSid() int64 {
-	if m != nil && m.Sid != nil {
-		return *m.Sid
+func (x *RudpMsgRegRs) GetPubkey() []byte {
+	if x != nil {
+		return x.Pubkey
+	}
+	return nil
+}
+
+func (x *RudpMsgRegRs) GetMac() []byte {
+	if x != nil {
+		return x.Mac
+	}
+	return nil
+}
+
+func (x *RudpMsgRegRs) GetSeqbits() int32 {
+	if x != nil && x.Seqbits != nil {
+		return *x.Seqbits
 	}
 	return 0
 }
 
-func (m *RudpMsgRegRs) GetCode() int64 {
-	if m != nil && m.Code != nil {
-		return *m.Code
+func (x *RudpMsgRegRs) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *RudpMsgRegRs) GetWnd() int32 {
+	if x != nil && x.Wnd != nil {
+		return *x.Wnd
 	}
 	return 0
 }
 
 type RudpMsgData struct {
-	Seq              *int64 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
-	Sid              *int64 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
-	Data             []byte `protobuf:"bytes,3,req,name=data" json:"data,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,req,name=data" json:"data,omitempty"`
+	Frag          *int32                 `protobuf:"varint,4,opt,name=frag" json:"frag,omitempty"`
+	Total         *int32                 `protobuf:"varint,5,opt,name=total" json:"total,omitempty"`
+	Mode          *int32                 `protobuf:"varint,6,opt,name=mode" json:"mode,omitempty"`
+	Stream        *int32                 `protobuf:"varint,7,opt,name=stream" json:"stream,omitempty"`
+	Ssn           *int64                 `protobuf:"varint,8,opt,name=ssn" json:"ssn,omitempty"`
+	Fin           *bool                  `protobuf:"varint,9,opt,name=fin" json:"fin,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgData) Reset() {
+	*x = RudpMsgData{}
+	mi := &file_rudp_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-func (m *RudpMsgData) Reset()                    { *m = RudpMsgData{} }
-func (m *RudpMsgData) String() string            { return proto.CompactTextString(m) }
-func (*RudpMsgData) ProtoMessage()               {}
-func (*RudpMsgData) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (x *RudpMsgData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgData) ProtoMessage() {}
+
+func (x *RudpMsgData) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
 
-func (m *RudpMsgData) GetSeq() int64 {
-	if m != nil && m.Seq != nil {
-		return *m.Seq
+// Deprecated: Use RudpMsgData.ProtoReflect.Descriptor instead.
+func (*RudpMsgData) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RudpMsgData) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
 	}
 	return 0
 }
 
-func (m *RudpMsgData) GetSid() int64 {
-	if m != nil && m.Sid != nil {
-		return *m.Sid
+func (x *RudpMsgData) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
 	}
 	return 0
 }
 
-func (m *RudpMsgData) GetData() []byte {
-	if m != nil {
-		return m.Data
+func (x *RudpMsgData) GetData() []byte {
+	if x != nil {
+		return x.Data
 	}
 	return nil
 }
 
+func (x *RudpMsgData) GetFrag() int32 {
+	if x != nil && x.Frag != nil {
+		return *x.Frag
+	}
+	return 0
+}
+
+func (x *RudpMsgData) GetTotal() int32 {
+	if x != nil && x.Total != nil {
+		return *x.Total
+	}
+	return 0
+}
+
+func (x *RudpMsgData) GetMode() int32 {
+	if x != nil && x.Mode != nil {
+		return *x.Mode
+	}
+	return 0
+}
+
+func (x *RudpMsgData) GetStream() int32 {
+	if x != nil && x.Stream != nil {
+		return *x.Stream
+	}
+	return 0
+}
+
+func (x *RudpMsgData) GetSsn() int64 {
+	if x != nil && x.Ssn != nil {
+		return *x.Ssn
+	}
+	return 0
+}
+
+func (x *RudpMsgData) GetFin() bool {
+	if x != nil && x.Fin != nil {
+		return *x.Fin
+	}
+	return false
+}
+
 type RudpMsgAck struct {
-	Seq              *int64 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
-	Sid              *int64 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Cum           *int64                 `protobuf:"varint,3,opt,name=cum" json:"cum,omitempty"`
+	Sack          *uint64                `protobuf:"varint,4,opt,name=sack" json:"sack,omitempty"`
+	Wnd           *int64                 `protobuf:"varint,5,opt,name=wnd" json:"wnd,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgAck) Reset() {
+	*x = RudpMsgAck{}
+	mi := &file_rudp_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgAck) ProtoMessage() {}
+
+func (x *RudpMsgAck) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgAck.ProtoReflect.Descriptor instead.
+func (*RudpMsgAck) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RudpMsgAck) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
+}
+
+func (x *RudpMsgAck) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
+
+func (x *RudpMsgAck) GetCum() int64 {
+	if x != nil && x.Cum != nil {
+		return *x.Cum
+	}
+	return 0
+}
+
+func (x *RudpMsgAck) GetSack() uint64 {
+	if x != nil && x.Sack != nil {
+		return *x.Sack
+	}
+	return 0
 }
 
-func (m *RudpMsgAck) Reset()                    { *m = RudpMsgAck{} }
-func (m *RudpMsgAck) String() string            { return proto.CompactTextString(m) }
-func (*RudpMsgAck) ProtoMessage()               {}
-func (*RudpMsgAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (x *RudpMsgAck) GetWnd() int64 {
+	if x != nil && x.Wnd != nil {
+		return *x.Wnd
+	}
+	return 0
+}
+
+type RudpMsgClose struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Code          *int64                 `protobuf:"varint,3,opt,name=code" json:"code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgClose) Reset() {
+	*x = RudpMsgClose{}
+	mi := &file_rudp_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgClose) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgClose) ProtoMessage() {}
+
+func (x *RudpMsgClose) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgClose.ProtoReflect.Descriptor instead.
+func (*RudpMsgClose) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RudpMsgClose) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
+}
+
+func (x *RudpMsgClose) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
+
+func (x *RudpMsgClose) GetCode() int64 {
+	if x != nil && x.Code != nil {
+		return *x.Code
+	}
+	return 0
+}
+
+type RudpMsgCloseRs struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Code          *int64                 `protobuf:"varint,3,req,name=code" json:"code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgCloseRs) Reset() {
+	*x = RudpMsgCloseRs{}
+	mi := &file_rudp_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgCloseRs) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgCloseRs) ProtoMessage() {}
+
+func (x *RudpMsgCloseRs) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgCloseRs.ProtoReflect.Descriptor instead.
+func (*RudpMsgCloseRs) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RudpMsgCloseRs) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
+}
+
+func (x *RudpMsgCloseRs) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
+
+func (x *RudpMsgCloseRs) GetCode() int64 {
+	if x != nil && x.Code != nil {
+		return *x.Code
+	}
+	return 0
+}
+
+type RudpMsgPing struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Ts            *int64                 `protobuf:"varint,3,opt,name=ts" json:"ts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgPing) Reset() {
+	*x = RudpMsgPing{}
+	mi := &file_rudp_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgPing) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgPing) ProtoMessage() {}
+
+func (x *RudpMsgPing) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgPing.ProtoReflect.Descriptor instead.
+func (*RudpMsgPing) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RudpMsgPing) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
+}
+
+func (x *RudpMsgPing) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
+
+func (x *RudpMsgPing) GetTs() int64 {
+	if x != nil && x.Ts != nil {
+		return *x.Ts
+	}
+	return 0
+}
 
-func (m *RudpMsgAck) GetSeq() int64 {
-	if m != nil && m.Seq != nil {
-		return *m.Seq
+type RudpMsgPong struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Ts            *int64                 `protobuf:"varint,3,opt,name=ts" json:"ts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgPong) Reset() {
+	*x = RudpMsgPong{}
+	mi := &file_rudp_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgPong) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgPong) ProtoMessage() {}
+
+func (x *RudpMsgPong) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgPong.ProtoReflect.Descriptor instead.
+func (*RudpMsgPong) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *RudpMsgPong) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
+}
+
+func (x *RudpMsgPong) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
+
+func (x *RudpMsgPong) GetTs() int64 {
+	if x != nil && x.Ts != nil {
+		return *x.Ts
 	}
 	return 0
 }
 
-func (m *RudpMsgAck) GetSid() int64 {
-	if m != nil && m.Sid != nil {
-		return *m.Sid
+type RudpMsgProbe struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Size          *int32                 `protobuf:"varint,3,req,name=size" json:"size,omitempty"`
+	Pad           []byte                 `protobuf:"bytes,4,opt,name=pad" json:"pad,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgProbe) Reset() {
+	*x = RudpMsgProbe{}
+	mi := &file_rudp_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgProbe) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgProbe) ProtoMessage() {}
+
+func (x *RudpMsgProbe) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgProbe.ProtoReflect.Descriptor instead.
+func (*RudpMsgProbe) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RudpMsgProbe) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
 	}
 	return 0
 }
 
-func init() {
-	proto.RegisterType((*RudpMessage)(nil), "rudpmsg.RudpMessage")
-	proto.RegisterType((*RudpMsgReg)(nil), "rudpmsg.RudpMsgReg")
-	proto.RegisterType((*RudpMsgRegRs)(nil), "rudpmsg.RudpMsgRegRs")
-	proto.RegisterType((*RudpMsgData)(nil), "rudpmsg.RudpMsgData")
-	proto.RegisterType((*RudpMsgAck)(nil), "rudpmsg.RudpMsgAck")
-	proto.RegisterEnum("rudpmsg.RudpMsgType", RudpMsgType_name, RudpMsgType_value)
+func (x *RudpMsgProbe) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
 }
 
-func init() { proto.RegisterFile("rudp.proto", fileDescriptor0) }
+func (x *RudpMsgProbe) GetSize() int32 {
+	if x != nil && x.Size != nil {
+		return *x.Size
+	}
+	return 0
+}
 
-var fileDescriptor0 = []byte{
-	// 235 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x90, 0x41, 0x4b, 0x03, 0x31,
-	0x10, 0x85, 0xe9, 0x66, 0x41, 0x18, 0x57, 0x8d, 0xa3, 0x87, 0x3d, 0x96, 0x9c, 0x16, 0x0f, 0x8b,
-	0xf8, 0x0f, 0x82, 0x5b, 0x7b, 0x28, 0x05, 0x99, 0xd6, 0x83, 0xa7, 0x25, 0x34, 0x21, 0x14, 0x91,
-	0x8d, 0x4d, 0x7a, 0xe8, 0xbf, 0x97, 0x0c, 0x4b, 0x55, 0xf0, 0xb0, 0xb7, 0xc7, 0xc7, 0x7b, 0x6f,
-	0x78, 0x03, 0x70, 0x38, 0xda, 0xd0, 0x86, 0xc3, 0x90, 0x06, 0xbc, 0xc8, 0xfa, 0x33, 0x7a, 0xb5,
-	0x82, 0x4b, 0x3a, 0xda, 0xb0, 0x76, 0x31, 0x1a, 0xef, 0xb0, 0x81, 0x32, 0x9d, 0x82, 0xab, 0x67,
-	0xf3, 0xa2, 0xb9, 0x7e, 0xba, 0x6f, 0x47, 0x5b, 0xcb, 0x9e, 0xe8, 0xb7, 0xa7, 0xe0, 0x88, 0x1d,
-	0x88, 0x50, 0x5a, 0x93, 0x4c, 0x5d, 0xcc, 0x8b, 0xa6, 0x22, 0xd6, 0xea, 0x11, 0x60, 0x34, 0x92,
-	0xf3, 0x28, 0x41, 0x44, 0xf7, 0xc5, 0x55, 0x82, 0xb2, 0x64, 0xb2, 0xb7, 0x1c, 0xc9, 0x64, 0x6f,
-	0xd5, 0x0b, 0x54, 0x3f, 0x09, 0x8a, 0x53, 0x32, 0xf9, 0xf2, 0x6e, 0xb0, 0xae, 0x16, 0x8c, 0x58,
-	0xab, 0xc5, 0x38, 0x23, 0xfa, 0xce, 0x24, 0x33, 0xb5, 0x86, 0x07, 0x88, 0x7f, 0x07, 0xe8, 0xdd,
-	0xc7, 0x94, 0x96, 0x87, 0xf7, 0xf3, 0xe1, 0xfc, 0x1b, 0xbc, 0x85, 0xab, 0xf5, 0x66, 0xd9, 0xd3,
-	0x5b, 0xf7, 0xda, 0x77, 0x7a, 0xab, 0xe5, 0x0c, 0x25, 0x54, 0x67, 0xa4, 0x9f, 0x57, 0xb2, 0xf8,
-	0x43, 0x68, 0xb1, 0x94, 0x02, 0xef, 0xe0, 0xe6, 0x37, 0xe9, 0x69, 0x23, 0xcb, 0xef, 0x00, 0x00,
-	0x00, 0xff, 0xff, 0x47, 0xfc, 0x32, 0xaa, 0xb0, 0x01, 0x00, 0x00,
+func (x *RudpMsgProbe) GetPad() []byte {
+	if x != nil {
+		return x.Pad
+	}
+	return nil
+}
+
+type RudpMsgProbeRs struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           *int64                 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Sid           *int64                 `protobuf:"varint,2,req,name=sid" json:"sid,omitempty"`
+	Size          *int32                 `protobuf:"varint,3,req,name=size" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RudpMsgProbeRs) Reset() {
+	*x = RudpMsgProbeRs{}
+	mi := &file_rudp_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RudpMsgProbeRs) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RudpMsgProbeRs) ProtoMessage() {}
+
+func (x *RudpMsgProbeRs) ProtoReflect() protoreflect.Message {
+	mi := &file_rudp_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RudpMsgProbeRs.ProtoReflect.Descriptor instead.
+func (*RudpMsgProbeRs) Descriptor() ([]byte, []int) {
+	return file_rudp_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RudpMsgProbeRs) GetSeq() int64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
+}
+
+func (x *RudpMsgProbeRs) GetSid() int64 {
+	if x != nil && x.Sid != nil {
+		return *x.Sid
+	}
+	return 0
+}
+
+func (x *RudpMsgProbeRs) GetSize() int32 {
+	if x != nil && x.Size != nil {
+		return *x.Size
+	}
+	return 0
+}
+
+var File_rudp_proto protoreflect.FileDescriptor
+
+const file_rudp_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"rudp.proto\x12\arudpmsg\"K\n" +
+	"\vRudpMessage\x12(\n" +
+	"\x04type\x18\x01 \x02(\x0e2\x14.rudpmsg.RudpMsgTypeR\x04type\x12\x12\n" +
+	"\x04data\x18\x02 \x02(\fR\x04data\"\xb2\x01\n" +
+	"\n" +
+	"RudpMsgReg\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x16\n" +
+	"\x06pubkey\x18\x03 \x01(\fR\x06pubkey\x12\x14\n" +
+	"\x05pskid\x18\x04 \x01(\fR\x05pskid\x12\x10\n" +
+	"\x03mac\x18\x05 \x01(\fR\x03mac\x12\x18\n" +
+	"\aseqbits\x18\x06 \x01(\x05R\aseqbits\x12\x14\n" +
+	"\x05nonce\x18\a \x01(\fR\x05nonce\x12\x10\n" +
+	"\x03wnd\x18\b \x01(\x05R\x03wnd\"\xb2\x01\n" +
+	"\fRudpMsgRegRs\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x12\n" +
+	"\x04code\x18\x03 \x02(\x03R\x04code\x12\x16\n" +
+	"\x06pubkey\x18\x04 \x01(\fR\x06pubkey\x12\x10\n" +
+	"\x03mac\x18\x05 \x01(\fR\x03mac\x12\x18\n" +
+	"\aseqbits\x18\x06 \x01(\x05R\aseqbits\x12\x14\n" +
+	"\x05nonce\x18\a \x01(\fR\x05nonce\x12\x10\n" +
+	"\x03wnd\x18\b \x01(\x05R\x03wnd\"\xbf\x01\n" +
+	"\vRudpMsgData\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x12\n" +
+	"\x04data\x18\x03 \x02(\fR\x04data\x12\x12\n" +
+	"\x04frag\x18\x04 \x01(\x05R\x04frag\x12\x14\n" +
+	"\x05total\x18\x05 \x01(\x05R\x05total\x12\x12\n" +
+	"\x04mode\x18\x06 \x01(\x05R\x04mode\x12\x16\n" +
+	"\x06stream\x18\a \x01(\x05R\x06stream\x12\x10\n" +
+	"\x03ssn\x18\b \x01(\x03R\x03ssn\x12\x10\n" +
+	"\x03fin\x18\t \x01(\bR\x03fin\"h\n" +
+	"\n" +
+	"RudpMsgAck\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x10\n" +
+	"\x03cum\x18\x03 \x01(\x03R\x03cum\x12\x12\n" +
+	"\x04sack\x18\x04 \x01(\x04R\x04sack\x12\x10\n" +
+	"\x03wnd\x18\x05 \x01(\x03R\x03wnd\"F\n" +
+	"\fRudpMsgClose\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x12\n" +
+	"\x04code\x18\x03 \x01(\x03R\x04code\"H\n" +
+	"\x0eRudpMsgCloseRs\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x12\n" +
+	"\x04code\x18\x03 \x02(\x03R\x04code\"A\n" +
+	"\vRudpMsgPing\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x0e\n" +
+	"\x02ts\x18\x03 \x01(\x03R\x02ts\"A\n" +
+	"\vRudpMsgPong\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x0e\n" +
+	"\x02ts\x18\x03 \x01(\x03R\x02ts\"X\n" +
+	"\fRudpMsgProbe\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x12\n" +
+	"\x04size\x18\x03 \x02(\x05R\x04size\x12\x10\n" +
+	"\x03pad\x18\x04 \x01(\fR\x03pad\"H\n" +
+	"\x0eRudpMsgProbeRs\x12\x10\n" +
+	"\x03seq\x18\x01 \x02(\x03R\x03seq\x12\x10\n" +
+	"\x03sid\x18\x02 \x02(\x03R\x03sid\x12\x12\n" +
+	"\x04size\x18\x03 \x02(\x05R\x04size*\xd5\x01\n" +
+	"\vRudpMsgType\x12\x11\n" +
+	"\rMSG_RUDP_DATA\x10\x01\x12\x10\n" +
+	"\fMSG_RUDP_ACK\x10\x02\x12\x10\n" +
+	"\fMSG_RUDP_REG\x10\x03\x12\x13\n" +
+	"\x0fMSG_RUDP_REG_RS\x10\x04\x12\x12\n" +
+	"\x0eMSG_RUDP_CLOSE\x10\x05\x12\x15\n" +
+	"\x11MSG_RUDP_CLOSE_RS\x10\x06\x12\x11\n" +
+	"\rMSG_RUDP_PING\x10\a\x12\x11\n" +
+	"\rMSG_RUDP_PONG\x10\b\x12\x12\n" +
+	"\x0eMSG_RUDP_PROBE\x10\t\x12\x15\n" +
+	"\x11MSG_RUDP_PROBE_RS\x10\n" +
+	""
+
+var (
+	file_rudp_proto_rawDescOnce sync.Once
+	file_rudp_proto_rawDescData []byte
+)
+
+func file_rudp_proto_rawDescGZIP() []byte {
+	file_rudp_proto_rawDescOnce.Do(func() {
+		file_rudp_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_rudp_proto_rawDesc), len(file_rudp_proto_rawDesc)))
+	})
+	return file_rudp_proto_rawDescData
+}
+
+var file_rudp_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_rudp_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_rudp_proto_goTypes = []any{
+	(RudpMsgType)(0),       // 0: rudpmsg.RudpMsgType
+	(*RudpMessage)(nil),    // 1: rudpmsg.RudpMessage
+	(*RudpMsgReg)(nil),     // 2: rudpmsg.RudpMsgReg
+	(*RudpMsgRegRs)(nil),   // 3: rudpmsg.RudpMsgRegRs
+	(*RudpMsgData)(nil),    // 4: rudpmsg.RudpMsgData
+	(*RudpMsgAck)(nil),     // 5: rudpmsg.RudpMsgAck
+	(*RudpMsgClose)(nil),   // 6: rudpmsg.RudpMsgClose
+	(*RudpMsgCloseRs)(nil), // 7: rudpmsg.RudpMsgCloseRs
+	(*RudpMsgPing)(nil),    // 8: rudpmsg.RudpMsgPing
+	(*RudpMsgPong)(nil),    // 9: rudpmsg.RudpMsgPong
+	(*RudpMsgProbe)(nil),   // 10: rudpmsg.RudpMsgProbe
+	(*RudpMsgProbeRs)(nil), // 11: rudpmsg.RudpMsgProbeRs
+}
+var file_rudp_proto_depIdxs = []int32{
+	0, // 0: rudpmsg.RudpMessage.type:type_name -> rudpmsg.RudpMsgType
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_rudp_proto_init() }
+func file_rudp_proto_init() {
+	if File_rudp_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_rudp_proto_rawDesc), len(file_rudp_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_rudp_proto_goTypes,
+		DependencyIndexes: file_rudp_proto_depIdxs,
+		EnumInfos:         file_rudp_proto_enumTypes,
+		MessageInfos:      file_rudp_proto_msgTypes,
+	}.Build()
+	File_rudp_proto = out.File
+	file_rudp_proto_goTypes = nil
+	file_rudp_proto_depIdxs = nil
 }
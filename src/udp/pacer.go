@@ -0,0 +1,66 @@
+package udpsocket
+
+import "sync"
+
+// Pacer is a token bucket spreading the datagrams of one flow over time.
+// The rate is in bytes per second, 0 sends without delay.
+type Pacer struct {
+	lock   sync.Mutex
+	rate   int64
+	burst  int64
+	tokens float64
+	lastTs int64
+}
+
+func (p *Pacer) SetRate(rate int64, burst int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.rate == 0 {
+		p.tokens = float64(burst)
+		p.lastTs = 0
+	}
+
+	p.rate = rate
+	p.burst = int64(burst)
+}
+
+func (p *Pacer) GetRate() int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.rate
+}
+
+// Reserve takes n bytes from the bucket and returns 0 when the datagram may
+// be sent at curTs, otherwise it takes nothing and returns the nanoseconds
+// to wait. A datagram larger than the burst goes out once the bucket is full.
+func (p *Pacer) Reserve(n int, curTs int64) int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.rate <= 0 {
+		return 0
+	}
+
+	if p.lastTs > 0 {
+		p.tokens += float64(curTs-p.lastTs) * float64(p.rate) / 1e9
+	}
+	p.lastTs = curTs
+
+	if p.tokens > float64(p.burst) {
+		p.tokens = float64(p.burst)
+	}
+
+	need := float64(n)
+	if need > float64(p.burst) {
+		need = float64(p.burst)
+	}
+
+	if p.tokens >= need {
+		p.tokens -= float64(n)
+		return 0
+	}
+
+	return int64((need-p.tokens)*1e9/float64(p.rate)) + 1
+}
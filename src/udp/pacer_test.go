@@ -0,0 +1,92 @@
+package udpsocket
+
+import "net"
+import "testing"
+import "time"
+
+func TestPacerReserve(t *testing.T) {
+	const ms = int64(time.Millisecond)
+
+	var p Pacer
+	if wait := p.Reserve(100000, 1); wait != 0 {
+		t.Fatalf("unpaced wait=%d", wait)
+	}
+
+	// 1000 bytes per second with a 1500 byte burst.
+	p.SetRate(1000, 1500)
+	curTs := int64(time.Second)
+
+	if wait := p.Reserve(1000, curTs); wait != 0 {
+		t.Fatalf("burst wait=%d", wait)
+	}
+	if wait := p.Reserve(1000, curTs); wait != 500*ms+1 {
+		t.Fatalf("rate wait=%d", wait)
+	}
+
+	// Refilled after the wait,
+	curTs += 500 * ms
+	if wait := p.Reserve(1000, curTs); wait != 0 {
+		t.Fatalf("wait=%d after refill", wait)
+	}
+
+	// up to the burst at most.
+	curTs += 10 * int64(time.Second)
+	if wait := p.Reserve(1500, curTs); wait != 0 {
+		t.Fatalf("wait=%d for a full burst", wait)
+	}
+	if wait := p.Reserve(1, curTs); wait != ms+1 {
+		t.Fatalf("wait=%d beyond the burst", wait)
+	}
+
+	// A datagram larger than the burst waits for a full bucket and leaves
+	// a debt.
+	curTs += 1500 * ms
+	if wait := p.Reserve(3000, curTs); wait != 0 {
+		t.Fatalf("wait=%d for an oversized datagram", wait)
+	}
+	if wait := p.Reserve(1, curTs); wait != 1501*ms+1 {
+		t.Fatalf("wait=%d after an oversized datagram", wait)
+	}
+}
+
+func TestPacedDataDrainsInOrder(t *testing.T) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	var u UdpSocket
+	if err := u.Listen("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	// 100 KB/s after a 1000 byte burst, the 10000 bytes below take 90ms.
+	var p Pacer
+	p.SetRate(100000, 1000)
+
+	start := time.Now()
+	dstAddr := peer.LocalAddr().(*net.UDPAddr)
+	for i := 0; i < 20; i++ {
+		b := make([]byte, 500)
+		b[0] = byte(i)
+		u.SendPacedData(b, dstAddr, &p)
+	}
+
+	b := make([]byte, 1000)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 20; i++ {
+		n, _, err := peer.ReadFromUDP(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 500 || b[0] != byte(i) {
+			t.Fatalf("datagram %d arrived as %d len=%d", i, b[0], n)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("paced datagrams sent in %v", elapsed)
+	}
+}
@@ -7,6 +7,7 @@ import "github.com/woodywanghg/gofclog"
 type BufferItem struct {
 	Data    []byte
 	DstAddr *net.UDPAddr
+	Pacer   *Pacer
 }
 
 type UdpSendBuffer struct {
@@ -14,11 +15,11 @@ type UdpSendBuffer struct {
 	lockBuff   sync.Mutex
 }
 
-func (p *UdpSendBuffer) Add(b []byte, dstAddr *net.UDPAddr) {
+func (p *UdpSendBuffer) Add(b []byte, dstAddr *net.UDPAddr, pacer *Pacer) {
 	p.lockBuff.Lock()
 	defer p.lockBuff.Unlock()
 
-	var item BufferItem = BufferItem{Data: b, DstAddr: dstAddr}
+	var item BufferItem = BufferItem{Data: b, DstAddr: dstAddr, Pacer: pacer}
 	p.bufferList = append(p.bufferList, item)
 
 	fclog.DEBUG("Add data to send buffer list len=%d addr=%v", len(p.bufferList), *dstAddr)
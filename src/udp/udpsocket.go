@@ -1,33 +1,43 @@
 package udpsocket
 
 import "net"
-
-//import "time"
+import "time"
 import "strings"
 import "strconv"
+import "sync"
+import "sync/atomic"
 
 import "github.com/woodywanghg/gofclog"
 
+const (
+	UDP_BUFFER_SIZE_DEFAULT = 1024
+)
+
 type UdpSocket struct {
 	port       int
 	ip         string
 	conn       *net.UDPConn
 	recv       UdpRecv
 	buff       []byte
+	buffSize   int64
 	sendBuffer UdpSendBuffer
 	localIp    string
 	localPort  int
 	writeChan  chan bool
+	closeChan  chan bool
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
 }
 
 func (u *UdpSocket) Listen(ip string, port int) error {
 
-	u.buff = make([]byte, 1024)
+	u.buff = make([]byte, u.GetRecvBufferSize())
 	u.ip = ip
 	u.port = port
 	u.localIp = ip
 	u.localPort = port
-	u.writeChan = make(chan bool)
+	u.writeChan = make(chan bool, 1)
+	u.closeChan = make(chan bool)
 
 	var err error = nil
 	u.conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(u.ip), Port: u.port})
@@ -37,6 +47,7 @@ func (u *UdpSocket) Listen(ip string, port int) error {
 
 	fclog.DEBUG("ListUDP OK. IP=%s PORT:%d", u.ip, u.port)
 
+	u.wg.Add(2)
 	go u.goRecv()
 	go u.goSend()
 
@@ -49,7 +60,9 @@ func (u *UdpSocket) DialUDP(ip string, port int) error {
 	u.port = port
 	u.localIp = ""
 	u.localPort = 0
-	u.buff = make([]byte, 1024)
+	u.buff = make([]byte, u.GetRecvBufferSize())
+	u.writeChan = make(chan bool, 1)
+	u.closeChan = make(chan bool)
 	srcAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
 	dstAddr := &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
 
@@ -66,6 +79,7 @@ func (u *UdpSocket) DialUDP(ip string, port int) error {
 		u.localPort, _ = strconv.Atoi(addrAry[1])
 	}
 
+	u.wg.Add(2)
 	go u.goRecv()
 	go u.goSend()
 
@@ -74,9 +88,19 @@ func (u *UdpSocket) DialUDP(ip string, port int) error {
 
 func (u *UdpSocket) goRecv() {
 
+	defer u.wg.Done()
+
 	for {
+		if size := u.GetRecvBufferSize(); len(u.buff) != size {
+			u.buff = make([]byte, size)
+		}
+
 		rLen, addr, err := u.conn.ReadFromUDP(u.buff)
 		if err != nil {
+			if u.isClosed() {
+				fclog.DEBUG("Udp socket closed, recv exit")
+				return
+			}
 			fclog.ERROR("ReadFromUDP error! err=%s", err.Error())
 			continue
 		}
@@ -88,23 +112,82 @@ func (u *UdpSocket) goRecv() {
 }
 
 func (u *UdpSocket) goSend() {
+
+	defer u.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	pending := make([]BufferItem, 0)
+
 	for {
+		pending = append(pending, u.sendBuffer.GetData()...)
 
-		<-u.writeChan
-		fclog.DEBUG("CHANNEL write")
-		u.sendUdpDataToPeer()
+		var delay int64 = 0
+		pending, delay = u.sendUdpDataToPeer(pending)
+
+		if len(pending) == 0 {
+			select {
+			case <-u.writeChan:
+			case <-u.closeChan:
+				fclog.DEBUG("Udp socket closed, send exit")
+				return
+			}
+			continue
+		}
+
+		timer.Reset(time.Duration(delay))
+
+		select {
+		case <-timer.C:
+		case <-u.writeChan:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-u.closeChan:
+			fclog.DEBUG("Udp socket closed, send exit")
+			return
+		}
 	}
 }
 
-func (u *UdpSocket) sendUdpDataToPeer() {
-	bufferList := u.sendBuffer.GetData()
+// sendUdpDataToPeer sends the datagrams their pacers allow and returns the
+// rest, in order, with the time until the next one may go out. Once a
+// datagram of a pacer has to wait, the later ones of that pacer wait too.
+func (u *UdpSocket) sendUdpDataToPeer(bufferList []BufferItem) ([]BufferItem, int64) {
+
+	curTs := time.Now().UnixNano()
+	rest := make([]BufferItem, 0)
+	blocked := make(map[*Pacer]bool, 0)
+	var delay int64 = 0
+
 	for _, v := range bufferList {
+		if v.Pacer != nil {
+			if blocked[v.Pacer] {
+				rest = append(rest, v)
+				continue
+			}
+
+			wait := v.Pacer.Reserve(len(v.Data), curTs)
+			if wait > 0 {
+				blocked[v.Pacer] = true
+				rest = append(rest, v)
+				if delay == 0 || wait < delay {
+					delay = wait
+				}
+				continue
+			}
+		}
+
 		sLen, err := u.conn.WriteToUDP(v.Data, v.DstAddr)
 		if err != nil {
 			fclog.ERROR("SendData error! err=%s, sLen=%d", err.Error(), sLen)
 			continue
 		}
 	}
+
+	return rest, delay
 }
 
 func (u *UdpSocket) SetUdpReceiver(recv UdpRecv) {
@@ -113,8 +196,18 @@ func (u *UdpSocket) SetUdpReceiver(recv UdpRecv) {
 }
 
 func (u *UdpSocket) SendData(b []byte, dstAddr *net.UDPAddr) {
-	u.sendBuffer.Add(b, dstAddr)
-	u.writeChan <- true
+	u.SendPacedData(b, dstAddr, nil)
+}
+
+// SendPacedData queues b to be sent when pacer allows, a nil pacer sends it
+// as soon as possible.
+func (u *UdpSocket) SendPacedData(b []byte, dstAddr *net.UDPAddr, pacer *Pacer) {
+	u.sendBuffer.Add(b, dstAddr, pacer)
+
+	select {
+	case u.writeChan <- true:
+	default:
+	}
 }
 
 func (u *UdpSocket) SendCriticalData(b []byte, dstAddr *net.UDPAddr) {
@@ -125,7 +218,35 @@ func (u *UdpSocket) SendCriticalData(b []byte, dstAddr *net.UDPAddr) {
 }
 
 func (u *UdpSocket) Close() {
-	u.conn.Close()
+	u.closeOnce.Do(func() {
+		close(u.closeChan)
+		u.conn.Close()
+	})
+
+	u.wg.Wait()
+}
+
+func (u *UdpSocket) isClosed() bool {
+	select {
+	case <-u.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetRecvBufferSize sets the largest datagram received in full, longer ones
+// are truncated.
+func (u *UdpSocket) SetRecvBufferSize(size int) {
+	atomic.StoreInt64(&u.buffSize, int64(size))
+}
+
+func (u *UdpSocket) GetRecvBufferSize() int {
+	size := int(atomic.LoadInt64(&u.buffSize))
+	if size <= 0 {
+		return UDP_BUFFER_SIZE_DEFAULT
+	}
+	return size
 }
 
 func (u *UdpSocket) GetIp() string {